@@ -0,0 +1,306 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the server settings. Values start from Default, are
+// overridden by the optional file named in SCRIBE_CONFIG_FILE and finally by
+// individual SCRIBE_* environment variables.
+type Config struct {
+	Addr            string   `json:"addr" yaml:"addr"`
+	WriteWait       Duration `json:"writeWait" yaml:"writeWait"`
+	PongWait        Duration `json:"pongWait" yaml:"pongWait"`
+	ReadBufferSize  int      `json:"readBufferSize" yaml:"readBufferSize"`
+	WriteBufferSize int      `json:"writeBufferSize" yaml:"writeBufferSize"`
+	// SendQueueSize is how many outbound events are buffered per client
+	SendQueueSize int `json:"sendQueueSize" yaml:"sendQueueSize"`
+	// RateLimit is the number of requests per second allowed per client IP, as
+	// seen in X-Forwarded-For, 0 disables it
+	RateLimit       float64  `json:"rateLimit" yaml:"rateLimit"`
+	MaxClients      int      `json:"maxClients" yaml:"maxClients"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	ReconnectHint   Duration `json:"reconnectHint" yaml:"reconnectHint"`
+	// IdleTimeout is how long a game may go without events before it expires,
+	// players are warned IdleWarning before that
+	IdleTimeout     Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	IdleWarning     Duration `json:"idleWarning" yaml:"idleWarning"`
+	JanitorInterval Duration `json:"janitorInterval" yaml:"janitorInterval"`
+	SnapshotDir     string   `json:"snapshotDir" yaml:"snapshotDir"`
+	// EventLogDir enables recording every game's events when set
+	EventLogDir string `json:"eventLogDir" yaml:"eventLogDir"`
+	// HistorySize is how many synced states each game keeps for undo
+	HistorySize int `json:"historySize" yaml:"historySize"`
+	// ChatHistorySize is how many chat messages are kept for joining players
+	ChatHistorySize int `json:"chatHistorySize" yaml:"chatHistorySize"`
+	// ChatMaxLength is the longest chat message in characters
+	ChatMaxLength int `json:"chatMaxLength" yaml:"chatMaxLength"`
+	// ChatBlockedWords are masked in chat messages
+	ChatBlockedWords []string `json:"chatBlockedWords" yaml:"chatBlockedWords"`
+	// DropTimeout is how long a game paused for a dropped player waits for
+	// them before resuming
+	DropTimeout Duration `json:"dropTimeout" yaml:"dropTimeout"`
+	// WebhookURLs are sent game lifecycle events, signed with WebhookSecret
+	WebhookURLs   []string `json:"webhookURLs" yaml:"webhookURLs"`
+	WebhookSecret string   `json:"webhookSecret,omitempty" yaml:"webhookSecret"`
+	// WebhookQueueDir keeps undelivered webhooks across restarts when set
+	WebhookQueueDir string `json:"webhookQueueDir" yaml:"webhookQueueDir"`
+	// WebhookMaxAttempts is how often a delivery is tried before it is
+	// dropped, retries back off exponentially starting at WebhookBackoff
+	WebhookMaxAttempts int      `json:"webhookMaxAttempts" yaml:"webhookMaxAttempts"`
+	WebhookBackoff     Duration `json:"webhookBackoff" yaml:"webhookBackoff"`
+
+	// AdminToken guards the admin endpoints, it is never exposed by Redacted
+	AdminToken string `json:"adminToken,omitempty" yaml:"adminToken"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:            ":8080",
+		WriteWait:       Duration(10 * time.Second),
+		PongWait:        Duration(60 * time.Second),
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		SendQueueSize:   256,
+		RateLimit:       1,
+		MaxClients:      4,
+		ShutdownTimeout: Duration(30 * time.Second),
+		ReconnectHint:   Duration(5 * time.Second),
+		IdleTimeout:     Duration(30 * time.Minute),
+		IdleWarning:     Duration(time.Minute),
+		JanitorInterval: Duration(30 * time.Second),
+		HistorySize:     50,
+		ChatHistorySize: 100,
+		ChatMaxLength:   500,
+		DropTimeout:     Duration(2 * time.Minute),
+
+		WebhookMaxAttempts: 10,
+		WebhookBackoff:     Duration(time.Second),
+	}
+}
+
+// Load builds the config from defaults, the optional config file and the
+// environment, and validates the result.
+func Load() (Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("SCRIBE_CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding config file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	return errors.Join(
+		envString("SCRIBE_ADDR", &c.Addr),
+		envDuration("SCRIBE_WRITE_WAIT", &c.WriteWait),
+		envDuration("SCRIBE_PONG_WAIT", &c.PongWait),
+		envInt("SCRIBE_READ_BUFFER_SIZE", &c.ReadBufferSize),
+		envInt("SCRIBE_WRITE_BUFFER_SIZE", &c.WriteBufferSize),
+		envInt("SCRIBE_SEND_QUEUE_SIZE", &c.SendQueueSize),
+		envFloat("SCRIBE_RATE_LIMIT", &c.RateLimit),
+		envInt("SCRIBE_MAX_CLIENTS", &c.MaxClients),
+		envDuration("SCRIBE_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout),
+		envDuration("SCRIBE_RECONNECT_HINT", &c.ReconnectHint),
+		envDuration("SCRIBE_IDLE_TIMEOUT", &c.IdleTimeout),
+		envDuration("SCRIBE_IDLE_WARNING", &c.IdleWarning),
+		envDuration("SCRIBE_JANITOR_INTERVAL", &c.JanitorInterval),
+		envString("SCRIBE_SNAPSHOT_DIR", &c.SnapshotDir),
+		envString("SCRIBE_EVENT_LOG_DIR", &c.EventLogDir),
+		envInt("SCRIBE_HISTORY_SIZE", &c.HistorySize),
+		envInt("SCRIBE_CHAT_HISTORY_SIZE", &c.ChatHistorySize),
+		envInt("SCRIBE_CHAT_MAX_LENGTH", &c.ChatMaxLength),
+		envList("SCRIBE_CHAT_BLOCKED_WORDS", &c.ChatBlockedWords),
+		envDuration("SCRIBE_DROP_TIMEOUT", &c.DropTimeout),
+		envList("SCRIBE_WEBHOOK_URLS", &c.WebhookURLs),
+		envString("SCRIBE_WEBHOOK_SECRET", &c.WebhookSecret),
+		envString("SCRIBE_WEBHOOK_QUEUE_DIR", &c.WebhookQueueDir),
+		envInt("SCRIBE_WEBHOOK_MAX_ATTEMPTS", &c.WebhookMaxAttempts),
+		envDuration("SCRIBE_WEBHOOK_BACKOFF", &c.WebhookBackoff),
+		envString("SCRIBE_ADMIN_TOKEN", &c.AdminToken),
+	)
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr must be set"))
+	}
+	if c.WriteWait <= 0 {
+		errs = append(errs, errors.New("writeWait must be positive"))
+	}
+	if c.PongWait <= 0 {
+		errs = append(errs, errors.New("pongWait must be positive"))
+	}
+	if c.ReadBufferSize <= 0 || c.WriteBufferSize <= 0 {
+		errs = append(errs, errors.New("readBufferSize and writeBufferSize must be positive"))
+	}
+	if c.SendQueueSize < 1 {
+		errs = append(errs, errors.New("sendQueueSize must be at least 1"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rateLimit must not be negative"))
+	}
+	if c.MaxClients < 1 {
+		errs = append(errs, errors.New("maxClients must be at least 1"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdownTimeout must be positive"))
+	}
+	if c.ReconnectHint < 0 {
+		errs = append(errs, errors.New("reconnectHint must not be negative"))
+	}
+	if c.IdleTimeout <= 0 || c.JanitorInterval <= 0 {
+		errs = append(errs, errors.New("idleTimeout and janitorInterval must be positive"))
+	}
+	if c.IdleWarning < 0 || c.IdleWarning >= c.IdleTimeout {
+		errs = append(errs, errors.New("idleWarning must be between 0 and idleTimeout"))
+	}
+	if c.HistorySize < 1 {
+		errs = append(errs, errors.New("historySize must be at least 1"))
+	}
+	if c.ChatHistorySize < 0 {
+		errs = append(errs, errors.New("chatHistorySize must not be negative"))
+	}
+	if c.ChatMaxLength < 1 {
+		errs = append(errs, errors.New("chatMaxLength must be at least 1"))
+	}
+	if c.DropTimeout <= 0 {
+		errs = append(errs, errors.New("dropTimeout must be positive"))
+	}
+	for _, raw := range c.WebhookURLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhookURLs: %q is not an http(s) URL", raw))
+		}
+	}
+	if len(c.WebhookURLs) > 0 && c.WebhookSecret == "" {
+		errs = append(errs, errors.New("webhookSecret must be set when webhookURLs are"))
+	}
+	if c.WebhookMaxAttempts < 1 {
+		errs = append(errs, errors.New("webhookMaxAttempts must be at least 1"))
+	}
+	if c.WebhookBackoff <= 0 {
+		errs = append(errs, errors.New("webhookBackoff must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// PingPeriod is how often pings are sent, it has to be shorter than PongWait.
+func (c Config) PingPeriod() time.Duration {
+	return (c.PongWait.Duration() * 9) / 10
+}
+
+// Redacted returns a copy of the config that is safe to expose.
+func (c Config) Redacted() Config {
+	c.AdminToken = ""
+	c.WebhookSecret = ""
+	return c
+}
+
+func envString(key string, dst *string) error {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+
+	return nil
+}
+
+// envList reads a comma separated list.
+func envList(key string, dst *[]string) error {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = strings.Split(v, ",")
+	}
+
+	return nil
+}
+
+func envInt(key string, dst *int) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", key, err)
+	}
+	*dst = i
+
+	return nil
+}
+
+func envFloat(key string, dst *float64) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", key, err)
+	}
+	*dst = f
+
+	return nil
+}
+
+func envDuration(key string, dst *Duration) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	if err := dst.UnmarshalText([]byte(v)); err != nil {
+		return fmt.Errorf("error parsing %s: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Bot plays a game from inside the server. Play receives the same events as
+// any other player through bot.Events and sends its own with bot.Send. It
+// should return once the events channel is closed, which happens when the
+// bot is removed from the game or the server shuts down.
+type Bot interface {
+	Play(bot *BotClient)
+}
+
+// BotFactory creates a bot for a game, a new one for every bot added.
+type BotFactory func() Bot
+
+// BotClient connects a Bot to its game in place of a websocket.
+type BotClient struct {
+	client *Client
+	events chan SocketEventStruct
+}
+
+type addBotPayload struct {
+	Bot      string `json:"bot"`
+	Username string `json:"username"`
+}
+
+// RegisterBot makes a bot available to the add bot event under name.
+func (hub *Hub) RegisterBot(name string, factory BotFactory) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.bots[name] = factory
+}
+
+func (hub *Hub) botFactory(name string) BotFactory {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	return hub.bots[name]
+}
+
+// UserID is the bot's userID in its game.
+func (bot *BotClient) UserID() string {
+	return bot.client.userID
+}
+
+// Events delivers the events sent to the bot, it is closed when the bot
+// leaves the game.
+func (bot *BotClient) Events() <-chan SocketEventStruct {
+	return bot.events
+}
+
+// Send handles an event from the bot as if a player had sent it over the
+// websocket. The payload is passed through JSON so handlers see the same
+// types they get from real clients.
+func (bot *BotClient) Send(eventName string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding bot payload: %w", err)
+	}
+
+	event := SocketEventStruct{EventName: eventName}
+	if err := json.Unmarshal(data, &event.EventPayload); err != nil {
+		return fmt.Errorf("error decoding bot payload: %w", err)
+	}
+
+	c := bot.client
+	if c.isClosed() {
+		return nil
+	}
+	// bots don't count as activity, a game left to its bots still expires
+	eventsIn.WithLabelValues(eventLabel(eventName)).Inc()
+	recordInbound(c, event)
+	handleSocketPayloadEvents(c, event)
+
+	return nil
+}
+
+// Leave takes the bot out of its game.
+func (bot *BotClient) Leave() {
+	bot.client.hub.unregister <- bot.client
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// SpawnBot adds a bot player to the game.
+func SpawnBot(hub *Hub, game *Game, bot Bot, username string) bool {
+	if !hub.trackPump() {
+		return false
+	}
+
+	sessionID, userID := game.identify("")
+	client := &Client{
+		hub:       hub,
+		send:      make(chan SocketEventStruct, hub.config.SendQueueSize),
+		userID:    userID,
+		sessionID: sessionID,
+		username:  username,
+		game:      game,
+		bot:       true,
+	}
+	bc := &BotClient{client: client, events: make(chan SocketEventStruct)}
+
+	client.enqueue(SocketEventStruct{
+		EventName: "session",
+		EventPayload: map[string]interface{}{
+			"sessionID":   client.sessionID,
+			"userID":      client.userID,
+			"spectator":   false,
+			"recordingID": game.recordingID,
+			"commitment":  game.commitment(),
+		},
+	})
+
+	// relay hands the queued events to the bot, the queue absorbs bursts
+	// just like a websocket client's
+	go func() {
+		defer close(bc.events)
+		for event := range client.send {
+			outboundQueueDepth.Dec()
+			bc.events <- event
+		}
+	}()
+
+	go func() {
+		defer hub.pumps.Done()
+		bot.Play(bc)
+		if !client.isClosed() {
+			bc.Leave()
+		}
+		// let the relay finish once the client is closed
+		for range bc.events {
+		}
+	}()
+
+	hub.register <- client
+	return true
+}
+
+// handleAddBotEvent lets the owner add one of the registered bots as a
+// player.
+func handleAddBotEvent(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+	game := client.game
+
+	var payload addBotPayload
+	decodePayload(socketEventPayload.EventPayload, &payload)
+	factory := client.hub.botFactory(payload.Bot)
+	if factory == nil {
+		sendError(client, eventName, "unknown bot")
+		return
+	}
+	if game.isFull() {
+		sendError(client, eventName, "game is full")
+		return
+	}
+	// bots join like any new player would
+	if game.isLocked() {
+		sendError(client, eventName, "game is locked")
+		return
+	}
+	if !game.canPlay("") {
+		sendError(client, eventName, "game has already started")
+		return
+	}
+	if payload.Username == "" {
+		payload.Username = payload.Bot + " bot"
+	}
+
+	if !SpawnBot(client.hub, game, factory(), truncate(payload.Username, maxReasonLength)) {
+		sendError(client, eventName, "server shutting down")
+	}
+}
+
+// passBotDelay is how long the pass bot waits before passing, so a turn
+// order of only bots doesn't spin.
+const passBotDelay = time.Second
+
+// passBot is a built in bot that readies up for ready checks and passes
+// every turn it gets.
+type passBot struct{}
+
+func (passBot) Play(bot *BotClient) {
+	var pass <-chan time.Time
+	for {
+		select {
+		case event, ok := <-bot.Events():
+			if !ok {
+				return
+			}
+			payload, _ := event.EventPayload.(map[string]interface{})
+
+			switch {
+			case event.EventName == "game state changed" && payload["status"] == StateReadyCheck:
+				bot.Send("ready", map[string]interface{}{})
+			case event.EventName == "turn changed" && payload["userID"] == bot.UserID():
+				pass = time.After(passBotDelay)
+			case event.EventName == "turn changed":
+				pass = nil
+			}
+
+		case <-pass:
+			pass = nil
+			bot.Send("pass", map[string]interface{}{})
+		}
+	}
+}
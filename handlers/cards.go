@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+)
+
+const maxDeckSize = 1000
+
+// card is a single card. Its ID is handed out after the deck is shuffled, so
+// it says nothing about the card until the card is seen.
+type card struct {
+	ID   string      `json:"id"`
+	Face interface{} `json:"face"`
+}
+
+// cardTable holds the game's cards. The deck is face down, the discard pile
+// and the table are face up, and each hand is only seen by its player.
+type cardTable struct {
+	deck    []card
+	discard []card
+	table   []card
+	hands   map[string][]card
+	nextID  int
+	// rng shuffles the deck. Unlike the game's seed it is never revealed,
+	// as its seed would give away the deck order and every hand
+	rng *randomSource
+}
+
+type uploadDeckPayload struct {
+	Cards []interface{} `json:"cards"`
+}
+
+type shuffleDeckPayload struct {
+	IncludeDiscard bool `json:"includeDiscard"`
+	IncludeTable   bool `json:"includeTable"`
+}
+
+type dealPayload struct {
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIDs"`
+}
+
+type cardsPayload struct {
+	CardIDs []string `json:"cardIDs"`
+}
+
+// shuffleDeck shuffles the deck and gives its cards new IDs. game.mu must be
+// held.
+func (cards *cardTable) shuffleDeck() {
+	cards.rng.next()
+	cards.rng.shuffle(len(cards.deck), func(i, j int) { cards.deck[i], cards.deck[j] = cards.deck[j], cards.deck[i] })
+	for i := range cards.deck {
+		cards.nextID++
+		cards.deck[i].ID = "c" + strconv.Itoa(cards.nextID)
+	}
+}
+
+// takeFromHand removes the cards with the given IDs from a hand, failing
+// without changing it when any of them isn't there.
+func (cards *cardTable) takeFromHand(userID string, ids []string) ([]card, bool) {
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var taken, kept []card
+	for _, c := range cards.hands[userID] {
+		if wanted[c.ID] {
+			taken = append(taken, c)
+			delete(wanted, c.ID)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(wanted) > 0 || len(taken) == 0 {
+		return nil, false
+	}
+	cards.hands[userID] = kept
+
+	return taken, true
+}
+
+// publicPayload describes the cards as everyone may see them. game.mu must be
+// held.
+func (cards *cardTable) publicPayload() map[string]interface{} {
+	hands := make(map[string]int)
+	for userID, hand := range cards.hands {
+		hands[userID] = len(hand)
+	}
+
+	return map[string]interface{}{
+		"deck":    len(cards.deck),
+		"discard": append([]card{}, cards.discard...),
+		"table":   append([]card{}, cards.table...),
+		"hands":   hands,
+	}
+}
+
+// sendCards tells every client what they may see of the cards after a move.
+// Everyone gets the counts and face up cards in "cards changed", and each
+// player gets their own hand in "hand updated".
+func sendCards(game *Game, action string, userID string, hub *Hub) {
+	game.mu.Lock()
+	if game.cards == nil {
+		game.mu.Unlock()
+		return
+	}
+	payload := game.cards.publicPayload()
+	hands := make(map[string][]card)
+	for id, hand := range game.cards.hands {
+		hands[id] = append([]card{}, hand...)
+	}
+	game.mu.Unlock()
+
+	payload["action"] = action
+	payload["userID"] = userID
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "cards changed",
+		EventPayload: payload,
+	}, "", hub.logger)
+
+	for _, c := range game.clientList() {
+		c.enqueue(SocketEventStruct{
+			EventName:    "hand updated",
+			EventPayload: map[string]interface{}{"cards": append([]card{}, hands[c.userID]...)},
+		})
+	}
+}
+
+// sendCardsTo catches a joining client up on the cards.
+func sendCardsTo(client *Client) {
+	game := client.game
+
+	game.mu.Lock()
+	if game.cards == nil {
+		game.mu.Unlock()
+		return
+	}
+	payload := game.cards.publicPayload()
+	hand := append([]card{}, game.cards.hands[client.userID]...)
+	game.mu.Unlock()
+
+	payload["action"] = "sync"
+	client.enqueue(SocketEventStruct{
+		EventName:    "cards changed",
+		EventPayload: payload,
+	})
+	client.enqueue(SocketEventStruct{
+		EventName:    "hand updated",
+		EventPayload: map[string]interface{}{"cards": hand},
+	})
+}
+
+// handleCardEvents handles the hidden deck. The owner uploads, shuffles and
+// deals the deck, and players draw cards and discard or reveal cards from
+// their own hand.
+func handleCardEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	if (eventName == "upload deck" || eventName == "shuffle deck" || eventName == "deal") && !requireOwner(client, eventName) {
+		return
+	}
+
+	var action string
+	var errMessage string
+
+	game.mu.Lock()
+	cards := game.cards
+	switch {
+	case eventName == "upload deck":
+		var payload uploadDeckPayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || len(payload.Cards) == 0 {
+			errMessage = "cards must list at least one card"
+			break
+		}
+		if len(payload.Cards) > maxDeckSize {
+			errMessage = "too many cards"
+			break
+		}
+
+		rng, err := newRandomSource()
+		if err != nil {
+			client.hub.logger.Error().Err(err).Msgf("Error seeding randomness for game %s", game.id)
+			errMessage = "could not shuffle the deck"
+			break
+		}
+
+		game.cards = &cardTable{hands: make(map[string][]card), rng: rng}
+		for _, face := range payload.Cards {
+			game.cards.deck = append(game.cards.deck, card{Face: face})
+		}
+		game.cards.shuffleDeck()
+		action = "upload"
+
+	case cards == nil:
+		errMessage = "the game has no deck"
+
+	case eventName == "shuffle deck":
+		var payload shuffleDeckPayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+
+		if payload.IncludeDiscard {
+			cards.deck = append(cards.deck, cards.discard...)
+			cards.discard = nil
+		}
+		if payload.IncludeTable {
+			cards.deck = append(cards.deck, cards.table...)
+			cards.table = nil
+		}
+		cards.shuffleDeck()
+		action = "shuffle"
+
+	case eventName == "draw":
+		var payload dealPayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+		if payload.Count <= 0 {
+			payload.Count = 1
+		}
+		if payload.Count > len(cards.deck) {
+			errMessage = "not enough cards in the deck"
+			break
+		}
+
+		cards.hands[client.userID] = append(cards.hands[client.userID], cards.deck[:payload.Count]...)
+		cards.deck = cards.deck[payload.Count:]
+		action = "draw"
+
+	case eventName == "deal":
+		var payload dealPayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+		if payload.Count <= 0 {
+			payload.Count = 1
+		}
+		players := make(map[string]bool)
+		for _, c := range game.players() {
+			players[c.userID] = true
+		}
+		userIDs := payload.UserIDs
+		if len(userIDs) == 0 {
+			for userID := range players {
+				userIDs = append(userIDs, userID)
+			}
+			sort.Strings(userIDs)
+		}
+		seen := make(map[string]bool)
+		for _, userID := range userIDs {
+			if !players[userID] || seen[userID] {
+				errMessage = "userIDs must list connected players once each"
+				break
+			}
+			seen[userID] = true
+		}
+		if errMessage != "" {
+			break
+		}
+		// divide rather than multiply so a huge count can't overflow
+		if len(userIDs) > 0 && payload.Count > len(cards.deck)/len(userIDs) {
+			errMessage = "not enough cards in the deck"
+			break
+		}
+
+		// one card at a time around the players, like a dealer would
+		for i := 0; i < payload.Count; i++ {
+			for _, userID := range userIDs {
+				cards.hands[userID] = append(cards.hands[userID], cards.deck[0])
+				cards.deck = cards.deck[1:]
+			}
+		}
+		action = "deal"
+
+	case eventName == "discard" || eventName == "reveal":
+		var payload cardsPayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+		taken, ok := cards.takeFromHand(client.userID, payload.CardIDs)
+		if !ok {
+			errMessage = "cardIDs must list cards in your hand"
+			break
+		}
+
+		if eventName == "discard" {
+			cards.discard = append(cards.discard, taken...)
+		} else {
+			cards.table = append(cards.table, taken...)
+		}
+		action = eventName
+	}
+	game.mu.Unlock()
+
+	if errMessage != "" {
+		sendError(client, eventName, errMessage)
+		return
+	}
+
+	sendCards(game, action, client.userID, client.hub)
+}
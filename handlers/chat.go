@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ChatFilter checks chat messages before they are sent. It returns the text
+// to send, which may be censored, or an error to reject the message with.
+type ChatFilter interface {
+	Filter(gameID string, userID string, text string) (string, error)
+}
+
+// WordFilter is a ChatFilter that masks blocked words.
+type WordFilter struct {
+	pattern *regexp.Regexp
+}
+
+// NewWordFilter masks the given words wherever they appear as whole words,
+// ignoring case.
+func NewWordFilter(words []string) *WordFilter {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return &WordFilter{}
+	}
+
+	return &WordFilter{pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+func (f *WordFilter) Filter(gameID string, userID string, text string) (string, error) {
+	if f.pattern == nil {
+		return text, nil
+	}
+
+	return f.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
+
+type chatMessage struct {
+	id       string
+	userID   string
+	username string
+	text     string
+	sentAt   time.Time
+	editedAt time.Time
+}
+
+type chatPayload struct {
+	MessageID string `json:"messageID"`
+	Text      string `json:"text"`
+}
+
+// maxMuteDuration is the longest timed mute, longer ones have to be lifted
+// with unmute.
+const maxMuteDuration = 24 * time.Hour
+
+type mutePayload struct {
+	UserID string `json:"userID"`
+	// DurationMs is nil for a mute that lasts until unmute
+	DurationMs *int64 `json:"durationMs"`
+}
+
+func (m *chatMessage) payload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"messageID": m.id,
+		"userID":    m.userID,
+		"username":  m.username,
+		"text":      m.text,
+		"sentAt":    millis(m.sentAt),
+	}
+	if !m.editedAt.IsZero() {
+		payload["editedAt"] = millis(m.editedAt)
+	}
+
+	return payload
+}
+
+// isMuted reports whether the session can't chat right now. game.mu must be
+// held.
+func (game *Game) isMuted(sessionID string, now time.Time) bool {
+	until, ok := game.mutes[sessionID]
+	return ok && (until.IsZero() || now.Before(until))
+}
+
+// findChatMessage returns the index of the message in the chat history.
+// game.mu must be held.
+func (game *Game) findChatMessage(id string) int {
+	for i, m := range game.chat {
+		if m.id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkChatText trims and filters chat text.
+func checkChatText(client *Client, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("text is required")
+	}
+	if utf8.RuneCountInString(text) > client.hub.config.ChatMaxLength {
+		return "", errors.New("message is too long")
+	}
+	if client.hub.chatFilter == nil {
+		return text, nil
+	}
+
+	return client.hub.chatFilter.Filter(client.game.id, client.userID, text)
+}
+
+// sendChatHistoryTo catches a joining client up on the chat.
+func sendChatHistoryTo(client *Client) {
+	game := client.game
+
+	game.mu.Lock()
+	messages := make([]map[string]interface{}, 0, len(game.chat))
+	for _, m := range game.chat {
+		messages = append(messages, m.payload())
+	}
+	game.mu.Unlock()
+
+	client.enqueue(SocketEventStruct{
+		EventName:    "chat history",
+		EventPayload: map[string]interface{}{"messages": messages},
+	})
+}
+
+// handleChatEvents handles the game chat. Players send messages and edit or
+// delete their own, the owner can delete any message and mute players.
+func handleChatEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	var payload chatPayload
+	decodePayload(socketEventPayload.EventPayload, &payload)
+
+	var text string
+	if eventName == "chat" || eventName == "chat edit" {
+		var err error
+		if text, err = checkChatText(client, payload.Text); err != nil {
+			sendError(client, eventName, err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	var event SocketEventStruct
+	var errMessage string
+
+	game.mu.Lock()
+	isOwner := game.owner == client
+	switch eventName {
+	case "chat":
+		if game.isMuted(client.sessionID, now) {
+			errMessage = "you are muted"
+			break
+		}
+
+		game.nextChatID++
+		m := &chatMessage{
+			id:       "m" + strconv.Itoa(game.nextChatID),
+			userID:   client.userID,
+			username: client.username,
+			text:     text,
+			sentAt:   now,
+		}
+		game.chat = append(game.chat, m)
+		if limit := client.hub.config.ChatHistorySize; len(game.chat) > limit {
+			game.chat = game.chat[len(game.chat)-limit:]
+		}
+		event = SocketEventStruct{EventName: "chat message", EventPayload: m.payload()}
+
+	case "chat edit":
+		i := game.findChatMessage(payload.MessageID)
+		if i < 0 || game.chat[i].userID != client.userID {
+			errMessage = "message not found"
+			break
+		}
+		if game.isMuted(client.sessionID, now) {
+			errMessage = "you are muted"
+			break
+		}
+
+		game.chat[i].text = text
+		game.chat[i].editedAt = now
+		event = SocketEventStruct{EventName: "chat edited", EventPayload: game.chat[i].payload()}
+
+	case "chat delete":
+		i := game.findChatMessage(payload.MessageID)
+		if i < 0 || (game.chat[i].userID != client.userID && !isOwner) {
+			errMessage = "message not found"
+			break
+		}
+
+		game.chat = append(game.chat[:i], game.chat[i+1:]...)
+		event = SocketEventStruct{
+			EventName: "chat deleted",
+			EventPayload: map[string]interface{}{
+				"messageID": payload.MessageID,
+				"userID":    client.userID,
+			},
+		}
+	}
+	game.mu.Unlock()
+
+	if errMessage != "" {
+		sendError(client, eventName, errMessage)
+		return
+	}
+
+	EmitToConnectedClients(game, event, "", client.hub.logger)
+}
+
+// handleMuteEvents lets the owner mute a player's chat, for durationMs or
+// until unmuted. Mutes follow the player's session across reconnects.
+func handleMuteEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+	game := client.game
+
+	var payload mutePayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.UserID == "" {
+		sendError(client, eventName, "invalid payload")
+		return
+	}
+	if eventName == "mute" && payload.DurationMs != nil && (*payload.DurationMs <= 0 || *payload.DurationMs > maxMuteDuration.Milliseconds()) {
+		sendError(client, eventName, "durationMs must be between 1 and a day")
+		return
+	}
+	target := game.findClient(payload.UserID)
+	if target == nil {
+		sendError(client, eventName, "player not found")
+		return
+	}
+
+	event := map[string]interface{}{
+		"userID": target.userID,
+		"muted":  eventName == "mute",
+	}
+
+	game.mu.Lock()
+	if eventName == "mute" {
+		var until time.Time
+		if payload.DurationMs != nil {
+			until = time.Now().Add(time.Duration(*payload.DurationMs) * time.Millisecond)
+			event["until"] = millis(until)
+		}
+		game.mutes[target.sessionID] = until
+	} else {
+		delete(game.mutes, target.sessionID)
+	}
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "player muted",
+		EventPayload: event,
+	}, "", client.hub.logger)
+}
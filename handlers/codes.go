@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// joinCodeAlphabet leaves out characters that are easily confused when read
+// aloud or typed, such as 0/O and 1/I.
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	joinCodeLength      = 6
+	joinCodeMaxAttempts = 10
+)
+
+type invite struct {
+	expiresAt time.Time
+	singleUse bool
+}
+
+type createInvitePayload struct {
+	SingleUse        bool `json:"singleUse"`
+	ExpiresInSeconds int  `json:"expiresInSeconds"`
+}
+
+func newJoinCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(joinCodeAlphabet)))
+	for i := 0; i < joinCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(joinCodeAlphabet[n.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+func newInviteToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateGameWithCode creates a game under a freshly generated join code that
+// is neither in use in the hub nor saved in the store.
+func CreateGameWithCode(hub *Hub, password string, opts GameOptions) *Game {
+	for i := 0; i < joinCodeMaxAttempts; i++ {
+		code, err := newJoinCode()
+		if err != nil {
+			hub.logger.Error().Err(err).Msg("Error generating join code")
+			return nil
+		}
+
+		if hub.store != nil {
+			exists, err := hub.store.HasGame(code)
+			if err != nil {
+				hub.logger.Error().Err(err).Msg("Error checking join code in store")
+				return nil
+			}
+			if exists {
+				continue
+			}
+		}
+
+		if game := CreateGame(hub, code, password, opts); game != nil {
+			return game
+		}
+	}
+
+	hub.logger.Error().Msg("Could not find a free join code")
+	return nil
+}
+
+// ResolveJoinCode finds a game by its join code. Codes are generated in
+// upper case, so a code typed in lower case is resolved too.
+func (hub *Hub) ResolveJoinCode(code string) *Game {
+	if game := hub.FindGame(code); game != nil {
+		return game
+	}
+
+	return hub.FindGame(strings.ToUpper(code))
+}
+
+// hasInvite reports whether token is a valid invite for the game without
+// using it up.
+func (game *Game) hasInvite(token string) bool {
+	return game.redeemInvite(token, false)
+}
+
+// useInvite reports whether token is a valid invite for the game, using it
+// up if it is single use.
+func (game *Game) useInvite(token string) bool {
+	return game.redeemInvite(token, true)
+}
+
+func (game *Game) redeemInvite(token string, spend bool) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	inv, ok := game.invites[token]
+	if !ok {
+		return false
+	}
+	if !inv.expiresAt.IsZero() && time.Now().After(inv.expiresAt) {
+		delete(game.invites, token)
+		return false
+	}
+	if spend && inv.singleUse {
+		delete(game.invites, token)
+	}
+
+	return true
+}
+
+// handleCreateInviteEvent lets the owner create an invite that joins the game
+// without the password. The token is only sent back to the owner.
+func handleCreateInviteEvent(client *Client, socketEventPayload SocketEventStruct) {
+	if !requireOwner(client, socketEventPayload.EventName) {
+		return
+	}
+
+	var payload createInvitePayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil {
+		sendError(client, socketEventPayload.EventName, "invalid payload")
+		return
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		client.hub.logger.Error().Err(err).Msg("Error generating invite token")
+		sendError(client, socketEventPayload.EventName, "could not create invite")
+		return
+	}
+
+	inv := &invite{singleUse: payload.SingleUse}
+	if payload.ExpiresInSeconds > 0 {
+		inv.expiresAt = time.Now().Add(time.Duration(payload.ExpiresInSeconds) * time.Second)
+	}
+
+	client.game.mu.Lock()
+	client.game.invites[token] = inv
+	client.game.mu.Unlock()
+
+	response := map[string]interface{}{
+		"joinCode":  client.game.id,
+		"token":     token,
+		"singleUse": inv.singleUse,
+	}
+	if !inv.expiresAt.IsZero() {
+		response["expiresAt"] = inv.expiresAt
+	}
+
+	client.enqueue(SocketEventStruct{
+		EventName:    "invite created",
+		EventPayload: response,
+	})
+}
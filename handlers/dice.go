@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxDice         = 100
+	maxDieSides     = 1000
+	maxShuffleItems = 1000
+)
+
+var diceTerm = regexp.MustCompile(`^(\d*)d(\d+)(?:(kh|kl)(\d+))?$|^(\d+)$`)
+
+type diceGroup struct {
+	count int
+	sides int
+	// keep is the number of highest (keep > 0) or lowest (keep < 0) dice
+	// that count, all of them count when it is 0
+	keep int
+	sign int
+}
+
+type rollPayload struct {
+	Notation string `json:"notation"`
+	Label    string `json:"label"`
+}
+
+type shufflePayload struct {
+	Items []interface{} `json:"items"`
+	Label string        `json:"label"`
+}
+
+// parseDice parses notation such as 3d6+2, 2d20kh1 or d8-1d4. Constant
+// terms are summed into the returned modifier.
+func parseDice(notation string) ([]diceGroup, int, error) {
+	notation = strings.ToLower(strings.ReplaceAll(notation, " ", ""))
+	if notation == "" {
+		return nil, 0, errors.New("notation is required")
+	}
+
+	var groups []diceGroup
+	modifier, dice := 0, 0
+	for len(notation) > 0 {
+		sign := 1
+		switch notation[0] {
+		case '-':
+			sign = -1
+			fallthrough
+		case '+':
+			notation = notation[1:]
+		}
+
+		end := strings.IndexAny(notation, "+-")
+		if end < 0 {
+			end = len(notation)
+		}
+		match := diceTerm.FindStringSubmatch(notation[:end])
+		notation = notation[end:]
+		if match == nil {
+			return nil, 0, errors.New("invalid dice notation")
+		}
+
+		if match[5] != "" {
+			constant, err := strconv.Atoi(match[5])
+			if err != nil {
+				return nil, 0, errors.New("invalid dice notation")
+			}
+			modifier += sign * constant
+			continue
+		}
+
+		group := diceGroup{count: 1, sign: sign}
+		if match[1] != "" {
+			group.count, _ = strconv.Atoi(match[1])
+		}
+		group.sides, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			group.keep, _ = strconv.Atoi(match[4])
+			if group.keep < 1 || group.keep > group.count {
+				return nil, 0, errors.New("can't keep more dice than are rolled")
+			}
+			if match[3] == "kl" {
+				group.keep = -group.keep
+			}
+		}
+
+		dice += group.count
+		if group.count < 1 || group.sides < 2 || group.sides > maxDieSides || dice > maxDice {
+			return nil, 0, errors.New("too many dice or sides")
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, modifier, nil
+}
+
+// roll rolls the group, returning every die and the total of the kept ones.
+func (group diceGroup) roll(rng *randomSource) ([]int, []bool, int) {
+	rolls := make([]int, group.count)
+	for i := range rolls {
+		rolls[i] = rng.intn(group.sides) + 1
+	}
+
+	kept := make([]bool, group.count)
+	order := make([]int, group.count)
+	for i := range order {
+		order[i] = i
+	}
+	// stable so ties keep the earlier die
+	sort.SliceStable(order, func(i, j int) bool {
+		if group.keep < 0 {
+			return rolls[order[i]] < rolls[order[j]]
+		}
+		return rolls[order[i]] > rolls[order[j]]
+	})
+	keep := group.keep
+	if keep < 0 {
+		keep = -keep
+	}
+	if keep == 0 {
+		keep = group.count
+	}
+
+	total := 0
+	for _, i := range order[:keep] {
+		kept[i] = true
+		total += rolls[i]
+	}
+
+	return rolls, kept, group.sign * total
+}
+
+// handleRollEvent rolls dice on the server and broadcasts the result.
+func handleRollEvent(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	var payload rollPayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil {
+		sendError(client, eventName, "invalid payload")
+		return
+	}
+	groups, modifier, err := parseDice(payload.Notation)
+	if err != nil {
+		sendError(client, eventName, err.Error())
+		return
+	}
+
+	game.mu.Lock()
+	rng := game.rng
+	nonce := rng.next()
+	commitment := rng.commitment()
+	dice := make([]map[string]interface{}, 0, len(groups))
+	total := modifier
+	for _, group := range groups {
+		rolls, kept, sum := group.roll(rng)
+		total += sum
+		dice = append(dice, map[string]interface{}{
+			"sides":    group.sides,
+			"rolls":    rolls,
+			"kept":     kept,
+			"negative": group.sign < 0,
+		})
+	}
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "roll result",
+		EventPayload: map[string]interface{}{
+			"userID":     client.userID,
+			"notation":   payload.Notation,
+			"label":      truncate(payload.Label, maxReasonLength),
+			"dice":       dice,
+			"modifier":   modifier,
+			"total":      total,
+			"nonce":      nonce,
+			"commitment": commitment,
+			"serverTime": millis(time.Now()),
+		},
+	}, "", client.hub.logger)
+}
+
+// handleShuffleEvent shuffles the given items on the server and broadcasts
+// them in their new order.
+func handleShuffleEvent(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	var payload shufflePayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || len(payload.Items) == 0 {
+		sendError(client, eventName, "items must list at least one item")
+		return
+	}
+	if len(payload.Items) > maxShuffleItems {
+		sendError(client, eventName, "too many items")
+		return
+	}
+
+	game.mu.Lock()
+	rng := game.rng
+	nonce := rng.next()
+	commitment := rng.commitment()
+	items := payload.Items
+	rng.shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "shuffle result",
+		EventPayload: map[string]interface{}{
+			"userID":     client.userID,
+			"label":      truncate(payload.Label, maxReasonLength),
+			"items":      items,
+			"nonce":      nonce,
+			"commitment": commitment,
+			"serverTime": millis(time.Now()),
+		},
+	}, "", client.hub.logger)
+}
+
+// handleRevealSeedEvent lets the owner publish the seed behind every roll
+// and shuffle so far. The game switches to a fresh seed, which is committed
+// to straight away.
+func handleRevealSeedEvent(client *Client, socketEventPayload SocketEventStruct) {
+	if !requireOwner(client, socketEventPayload.EventName) {
+		return
+	}
+	game := client.game
+
+	next, err := newRandomSource()
+	if err != nil {
+		client.hub.logger.Error().Err(err).Msgf("Error seeding randomness for game %s", game.id)
+		sendError(client, socketEventPayload.EventName, "could not reveal the seed")
+		return
+	}
+
+	game.mu.Lock()
+	previous := game.rng
+	game.rng = next
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "seed revealed",
+		EventPayload: map[string]interface{}{
+			"userID":         client.userID,
+			"seed":           hex.EncodeToString(previous.seed),
+			"commitment":     previous.commitment(),
+			"draws":          previous.nonce,
+			"nextCommitment": next.commitment(),
+		},
+	}, "", client.hub.logger)
+}
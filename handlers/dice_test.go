@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		notation string
+		groups   []diceGroup
+		modifier int
+	}{
+		{"d20", []diceGroup{{count: 1, sides: 20, sign: 1}}, 0},
+		{"3d6+2", []diceGroup{{count: 3, sides: 6, sign: 1}}, 2},
+		{"2d20kh1", []diceGroup{{count: 2, sides: 20, keep: 1, sign: 1}}, 0},
+		{"4d6kl3", []diceGroup{{count: 4, sides: 6, keep: -3, sign: 1}}, 0},
+		{"d8-1d4", []diceGroup{{count: 1, sides: 8, sign: 1}, {count: 1, sides: 4, sign: -1}}, 0},
+		{"-2d6+3-1", []diceGroup{{count: 2, sides: 6, sign: -1}}, 2},
+		{" 2 D 10 + 1 ", []diceGroup{{count: 2, sides: 10, sign: 1}}, 1},
+		{"5", nil, 5},
+		{"100d1000", []diceGroup{{count: 100, sides: 1000, sign: 1}}, 0},
+		{"60d6+40d4", []diceGroup{{count: 60, sides: 6, sign: 1}, {count: 40, sides: 4, sign: 1}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notation, func(t *testing.T) {
+			groups, modifier, err := parseDice(tt.notation)
+			if err != nil {
+				t.Fatalf("parseDice(%q) returned error: %v", tt.notation, err)
+			}
+			if !reflect.DeepEqual(groups, tt.groups) || modifier != tt.modifier {
+				t.Errorf("parseDice(%q) = %+v, %d, want %+v, %d", tt.notation, groups, modifier, tt.groups, tt.modifier)
+			}
+		})
+	}
+}
+
+func TestParseDiceRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"abc",
+		"3d6+",
+		"d",
+		"0d6",
+		"1d1",
+		"1d1001",
+		"101d6",
+		"60d6+41d6",
+		"2d6kh3",
+		"2d6kh0",
+		"2d6kx1",
+		"3d6*2",
+	}
+
+	for _, notation := range tests {
+		t.Run(notation, func(t *testing.T) {
+			if groups, modifier, err := parseDice(notation); err == nil {
+				t.Errorf("parseDice(%q) = %+v, %d, want an error", notation, groups, modifier)
+			}
+		})
+	}
+}
+
+func TestDiceGroupRollKeeps(t *testing.T) {
+	tests := []struct {
+		name  string
+		group diceGroup
+	}{
+		{"all", diceGroup{count: 4, sides: 6, sign: 1}},
+		{"keep highest", diceGroup{count: 4, sides: 6, keep: 3, sign: 1}},
+		{"keep lowest", diceGroup{count: 4, sides: 6, keep: -1, sign: 1}},
+		{"negative", diceGroup{count: 2, sides: 20, keep: 1, sign: -1}},
+	}
+
+	rng := &randomSource{seed: make([]byte, 32)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				rng.next()
+				rolls, kept, total := tt.group.roll(rng)
+
+				keep := tt.group.keep
+				if keep < 0 {
+					keep = -keep
+				}
+				if keep == 0 {
+					keep = tt.group.count
+				}
+
+				var keptRolls, droppedRolls []int
+				for j, roll := range rolls {
+					if roll < 1 || roll > tt.group.sides {
+						t.Fatalf("roll %d out of range in %v", roll, rolls)
+					}
+					if kept[j] {
+						keptRolls = append(keptRolls, roll)
+					} else {
+						droppedRolls = append(droppedRolls, roll)
+					}
+				}
+				if len(keptRolls) != keep {
+					t.Fatalf("kept %d dice of %v, want %d", len(keptRolls), rolls, keep)
+				}
+
+				sum := 0
+				for _, roll := range keptRolls {
+					sum += roll
+				}
+				if total != tt.group.sign*sum {
+					t.Fatalf("total %d for kept %v, want %d", total, keptRolls, tt.group.sign*sum)
+				}
+
+				sort.Ints(keptRolls)
+				sort.Ints(droppedRolls)
+				if len(droppedRolls) == 0 {
+					continue
+				}
+				if tt.group.keep > 0 && droppedRolls[len(droppedRolls)-1] > keptRolls[0] {
+					t.Fatalf("dropped %v above kept %v", droppedRolls, keptRolls)
+				}
+				if tt.group.keep < 0 && droppedRolls[0] < keptRolls[len(keptRolls)-1] {
+					t.Fatalf("dropped %v below kept %v", droppedRolls, keptRolls)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import "time"
+
+// pausedBlockedEvents change the game and are rejected while it is paused.
+var pausedBlockedEvents = map[string]bool{
+	"sync":           true,
+	"hydrate":        true,
+	"message":        true,
+	"pass":           true,
+	"skip":           true,
+	"reverse":        true,
+	"set turn order": true,
+	"roll":           true,
+	"shuffle":        true,
+	"upload deck":    true,
+	"shuffle deck":   true,
+	"draw":           true,
+	"deal":           true,
+	"discard":        true,
+	"reveal":         true,
+	"undo":           true,
+	"redo":           true,
+	"restore":        true,
+	"timer start":    true,
+	"timer resume":   true,
+	"timer add":      true,
+}
+
+type replacePlayerPayload struct {
+	UserID     string `json:"userID"`
+	WithUserID string `json:"withUserID"`
+}
+
+func (game *Game) isPaused() bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.status == StatePaused
+}
+
+// missingList returns the players the game is waiting for. game.mu must be
+// held.
+func (game *Game) missingList() []string {
+	missing := make([]string, 0, len(game.missing))
+	for userID := range game.missing {
+		missing = append(missing, userID)
+	}
+
+	return missing
+}
+
+// stopDropTimer stops waiting for missing players. game.mu must be held.
+func (game *Game) stopDropTimer() {
+	game.missing = make(map[string]bool)
+	if game.dropTimer != nil {
+		game.dropTimer.Stop()
+		game.dropTimer = nil
+	}
+}
+
+// handlePlayerDrop pauses a game in progress when a player disconnects and
+// the game is set to wait for dropped players.
+func handlePlayerDrop(game *Game, client *Client, hub *Hub) {
+	game.mu.Lock()
+	if client.spectator || !game.pauseOnDrop || (game.status != StateInProgress && len(game.missing) == 0) {
+		game.mu.Unlock()
+		return
+	}
+
+	var payload map[string]interface{}
+	if game.status == StateInProgress {
+		payload = game.setStatus(StatePaused, "")
+	}
+	game.missing[client.userID] = true
+	if game.dropTimer == nil {
+		timeout := hub.config.DropTimeout.Duration()
+		game.dropTimer = time.AfterFunc(timeout, func() { dropTimedOut(game, hub) })
+		game.resumesAt = time.Now().Add(timeout)
+	}
+	if payload != nil {
+		payload["reason"] = "player dropped"
+		payload["missing"] = game.missingList()
+		payload["resumesAt"] = millis(game.resumesAt)
+	}
+	game.mu.Unlock()
+
+	hub.logger.Info().Msgf("Player %s dropped from game %s", client.userID, game.id)
+	announceStatus(game, payload, hub)
+}
+
+// handlePlayerReturn resumes the game once the last missing player is back.
+func handlePlayerReturn(game *Game, client *Client, hub *Hub) {
+	game.mu.Lock()
+	if !game.missing[client.userID] || client.spectator {
+		game.mu.Unlock()
+		return
+	}
+
+	delete(game.missing, client.userID)
+	payload := game.resumeIfComplete("player returned")
+	game.mu.Unlock()
+
+	announceStatus(game, payload, hub)
+}
+
+// resumeIfComplete resumes the game when no player is missing anymore. It
+// returns the state change to announce, or nil. game.mu must be held.
+func (game *Game) resumeIfComplete(reason string) map[string]interface{} {
+	if len(game.missing) > 0 || game.status != StatePaused {
+		return nil
+	}
+
+	game.stopDropTimer()
+	payload := game.setStatus(StateInProgress, "")
+	payload["reason"] = reason
+
+	return payload
+}
+
+// dropTimedOut resumes the game without the missing players once the drop
+// timeout elapsed.
+func dropTimedOut(game *Game, hub *Hub) {
+	game.mu.Lock()
+	if game.dropTimer == nil {
+		game.mu.Unlock()
+		return
+	}
+	missing := game.missingList()
+	game.stopDropTimer()
+	payload := game.resumeIfComplete("drop timeout")
+	if payload != nil {
+		payload["missing"] = missing
+	}
+	game.mu.Unlock()
+
+	announceStatus(game, payload, hub)
+}
+
+// handleReplacePlayerEvent lets the owner give a missing player's place to
+// a connected player or spectator, who takes over their turns, cards and
+// clocks.
+func handleReplacePlayerEvent(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+	game := client.game
+
+	var payload replacePlayerPayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.UserID == "" {
+		sendError(client, eventName, "invalid payload")
+		return
+	}
+	replacement := game.findClient(payload.WithUserID)
+	if replacement == nil {
+		sendError(client, eventName, "replacement must be connected")
+		return
+	}
+
+	game.mu.Lock()
+	if !game.missing[payload.UserID] {
+		game.mu.Unlock()
+		sendError(client, eventName, "player isn't missing")
+		return
+	}
+
+	delete(game.missing, payload.UserID)
+	game.replacePlayer(payload.UserID, replacement)
+	changed := game.resumeIfComplete("player replaced")
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "player replaced",
+		EventPayload: map[string]interface{}{
+			"userID":     payload.UserID,
+			"withUserID": replacement.userID,
+			"by":         client.userID,
+		},
+	}, "", client.hub.logger)
+	announceStatus(game, changed, client.hub)
+	sendCards(game, "replace", client.userID, client.hub)
+	broadcastRoster(game, client.hub)
+}
+
+// replacePlayer moves everything that belongs to userID over to the
+// replacement. game.mu must be held.
+func (game *Game) replacePlayer(userID string, replacement *Client) {
+	if replacement.spectator {
+		replacement.spectator = false
+		game.spectating[replacement.sessionID] = false
+		connectedClients.WithLabelValues(roleSpectator).Dec()
+		connectedClients.WithLabelValues(rolePlayer).Inc()
+	}
+
+	if game.turns != nil {
+		for i, id := range game.turns.order {
+			if id == userID {
+				game.turns.order[i] = replacement.userID
+			}
+		}
+	}
+	if game.cards != nil {
+		game.cards.hands[replacement.userID] = append(game.cards.hands[replacement.userID], game.cards.hands[userID]...)
+		delete(game.cards.hands, userID)
+	}
+	for _, timer := range game.timers {
+		if timer.userID == userID {
+			timer.userID = replacement.userID
+		}
+	}
+	if i := game.seatOf(userID); i >= 0 {
+		if j := game.seatOf(replacement.userID); j >= 0 {
+			game.seats[j].userID = ""
+		}
+		game.seats[i].userID = replacement.userID
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	directionIn  = "in"
+	directionOut = "out"
+	// directionMeta entries describe the recording rather than an event
+	directionMeta = "meta"
+)
+
+// participantEvent records a session that took part in the game, so it can
+// replay the recording after the game is gone.
+const participantEvent = "participant"
+
+// ErrNoEventLog is returned when a recording has no events.
+var ErrNoEventLog = errors.New("no event log for recording")
+
+// unloggedEvents carry secrets, or cards only one player may see, and are
+// never written to the event log.
+var unloggedEvents = map[string]bool{
+	"session":         true,
+	"invite created":  true,
+	"change password": true,
+	"hand updated":    true,
+}
+
+// EventLogEntry is a single recorded event. Inbound events carry their
+// sender in UserID. Outbound events are recorded once per EventID and list
+// everyone they were sent to in Recipients.
+type EventLogEntry struct {
+	Seq          uint64    `json:"seq"`
+	EventID      uint64    `json:"eventID,omitempty"`
+	Time         time.Time `json:"time"`
+	UserID       string    `json:"userID,omitempty"`
+	Recipients   []string  `json:"recipients,omitempty"`
+	Direction    string    `json:"direction"`
+	EventName    string    `json:"eventName"`
+	EventPayload any       `json:"eventPayload"`
+}
+
+// EventLog stores recordings, each one the events of a single game. Games are
+// recorded under their recordingID rather than their id, which a later game
+// may reuse.
+type EventLog interface {
+	// Append records entry for the recording, assigning its sequence number
+	Append(recordingID string, entry EventLogEntry) error
+	// Export writes the recording's entries to w as NDJSON
+	Export(recordingID string, w io.Writer) error
+	// Close releases anything held open for the recording
+	Close(recordingID string) error
+}
+
+// FileEventLog appends each recording's events to its own NDJSON file in dir.
+type FileEventLog struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[string]*eventLogFile
+}
+
+type eventLogFile struct {
+	file *os.File
+	seq  uint64
+}
+
+func NewFileEventLog(dir string) (*FileEventLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating event log dir: %w", err)
+	}
+
+	return &FileEventLog{dir: dir, files: make(map[string]*eventLogFile)}, nil
+}
+
+func (l *FileEventLog) Append(recordingID string, entry EventLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := l.open(recordingID)
+	if err != nil {
+		return err
+	}
+
+	f.seq++
+	entry.Seq = f.seq
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding event log entry: %w", err)
+	}
+
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing event log: %w", err)
+	}
+
+	return nil
+}
+
+func (l *FileEventLog) Export(recordingID string, w io.Writer) error {
+	file, err := os.Open(l.path(recordingID))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoEventLog
+	}
+	if err != nil {
+		return fmt.Errorf("error opening event log: %w", err)
+	}
+	defer file.Close()
+
+	// entries are written whole while l.mu is held, so the size taken under
+	// the lock always ends on a complete line
+	l.mu.Lock()
+	info, err := file.Stat()
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error reading event log: %w", err)
+	}
+
+	if _, err := io.Copy(w, io.LimitReader(file, info.Size())); err != nil {
+		return fmt.Errorf("error reading event log: %w", err)
+	}
+
+	return nil
+}
+
+func (l *FileEventLog) Close(recordingID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.files[recordingID]
+	if !ok {
+		return nil
+	}
+	delete(l.files, recordingID)
+
+	return f.file.Close()
+}
+
+// open returns the open log for recordingID, continuing the sequence of an
+// existing file. l.mu must be held.
+func (l *FileEventLog) open(recordingID string) (*eventLogFile, error) {
+	if f, ok := l.files[recordingID]; ok {
+		return f, nil
+	}
+
+	file, err := os.OpenFile(l.path(recordingID), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening event log: %w", err)
+	}
+
+	f := &eventLogFile{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		f.seq++
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading event log: %w", err)
+	}
+
+	l.files[recordingID] = f
+	return f, nil
+}
+
+func (l *FileEventLog) path(recordingID string) string {
+	return filepath.Join(l.dir, url.PathEscape(recordingID)+".ndjson")
+}
+
+// eventRecorderQueueSize is how many entries can wait for the recorder
+// before recording blocks.
+const eventRecorderQueueSize = 1024
+
+// eventRecorder writes to the event log from its own goroutine, so events
+// keep their order without broadcasts or the hub waiting on the disk.
+type eventRecorder struct {
+	log    EventLog
+	logger zerolog.Logger
+	ops    chan recorderOp
+}
+
+// recorderOp appends entry to the recording, closes the log when entry is
+// nil, or with flushed set, closes flushed once everything queued before it
+// has been written.
+type recorderOp struct {
+	recordingID string
+	entry       *EventLogEntry
+	flushed     chan struct{}
+}
+
+func newEventRecorder(log EventLog, logger zerolog.Logger) *eventRecorder {
+	r := &eventRecorder{log: log, logger: logger, ops: make(chan recorderOp, eventRecorderQueueSize)}
+	go r.run()
+
+	return r
+}
+
+func (r *eventRecorder) run() {
+	for op := range r.ops {
+		switch {
+		case op.flushed != nil:
+			close(op.flushed)
+		case op.entry != nil:
+			if err := r.log.Append(op.recordingID, *op.entry); err != nil {
+				r.logger.Error().Err(err).Msgf("Error appending to recording %s", op.recordingID)
+			}
+		default:
+			if err := r.log.Close(op.recordingID); err != nil {
+				r.logger.Error().Err(err).Msgf("Error closing recording %s", op.recordingID)
+			}
+		}
+	}
+}
+
+func (r *eventRecorder) record(recordingID string, entry EventLogEntry) {
+	r.ops <- recorderOp{recordingID: recordingID, entry: &entry}
+}
+
+// close closes the recording once its queued entries are written.
+func (r *eventRecorder) close(recordingID string) {
+	r.ops <- recorderOp{recordingID: recordingID}
+}
+
+// flush waits until everything recorded so far has been written.
+func (r *eventRecorder) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case r.ops <- recorderOp{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordInbound logs an event the client sent, if event logging is enabled.
+func recordInbound(client *Client, event SocketEventStruct) {
+	recorder := client.hub.recorder
+	if recorder == nil || unloggedEvents[event.EventName] {
+		return
+	}
+
+	recorder.record(client.game.recordingID, EventLogEntry{
+		Time:         time.Now(),
+		UserID:       client.userID,
+		Direction:    directionIn,
+		EventName:    event.EventName,
+		EventPayload: recordedPayload(event),
+	})
+}
+
+// recordParticipant logs a hash of the client's session, never the session
+// itself since exported logs must not let anyone take over a player.
+func recordParticipant(client *Client) {
+	recorder := client.hub.recorder
+	if recorder == nil {
+		return
+	}
+
+	recorder.record(client.game.recordingID, EventLogEntry{
+		Time:         time.Now(),
+		UserID:       client.userID,
+		Direction:    directionMeta,
+		EventName:    participantEvent,
+		EventPayload: map[string]string{"sessionHash": sessionHash(client.sessionID)},
+	})
+}
+
+func sessionHash(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordOutbound logs an event once with everyone it was sent to, if event
+// logging is enabled.
+func recordOutbound(game *Game, event SocketEventStruct, recipients []string) {
+	recorder := game.hub.recorder
+	if recorder == nil || len(recipients) == 0 || unloggedEvents[event.EventName] {
+		return
+	}
+
+	recorder.record(game.recordingID, EventLogEntry{
+		EventID:      event.id,
+		Time:         time.Now(),
+		Recipients:   recipients,
+		Direction:    directionOut,
+		EventName:    event.EventName,
+		EventPayload: recordedPayload(event),
+	})
+}
+
+// recordedPayload encodes the payload right away, the recorder writes it
+// later and handlers are free to reuse their payload maps.
+func recordedPayload(event SocketEventStruct) any {
+	data, err := json.Marshal(event.EventPayload)
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(data)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import "encoding/json"
+
+// decodePayload converts a decoded event payload into a typed struct.
+func decodePayload(payload any, dst any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+// requireOwner reports whether client owns its game, telling the client
+// why its event was rejected when it doesn't.
+func requireOwner(client *Client, eventName string) bool {
+	if client.game.isOwner(client) {
+		return true
+	}
+
+	sendError(client, eventName, "only the owner can do this")
+	return false
+}
+
+// sendError tells a single client why the event it sent was rejected.
+func sendError(client *Client, eventName string, message string) {
+	client.enqueue(SocketEventStruct{
+		EventName: "error",
+		EventPayload: map[string]interface{}{
+			"event":   eventName,
+			"message": message,
+		},
+	})
+}
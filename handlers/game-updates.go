@@ -0,0 +1,118 @@
+package handlers
+
+import "strings"
+
+type changePasswordPayload struct {
+	Password string `json:"password"`
+}
+
+// updateGamePayload only changes the fields that are set.
+type updateGamePayload struct {
+	Title       *string        `json:"title"`
+	GameType    *string        `json:"gameType"`
+	Visibility  *string        `json:"visibility"`
+	Settings    map[string]any `json:"settings"`
+	PauseOnDrop *bool          `json:"pauseOnDrop"`
+}
+
+func (game *Game) isLocked() bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.locked
+}
+
+// hasSession reports whether sessionID belongs to a player who has already
+// been in the game, those players can rejoin a locked game without the
+// current password.
+func (game *Game) hasSession(sessionID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	_, ok := game.sessions[sessionID]
+	return ok
+}
+
+// handleGameUpdateEvents handles the owner's lock, unlock, change password
+// and update game events, announcing every change with a game updated event.
+func handleGameUpdateEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+
+	game := client.game
+	var changed []string
+
+	switch eventName {
+	case "lock", "unlock":
+		game.mu.Lock()
+		game.locked = eventName == "lock"
+		game.mu.Unlock()
+		changed = append(changed, "locked")
+
+	case "change password":
+		var payload changePasswordPayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil {
+			sendError(client, eventName, "invalid payload")
+			return
+		}
+		if payload.Password == "" || strings.Contains(payload.Password, "/") {
+			sendError(client, eventName, "password is required and can't contain /")
+			return
+		}
+
+		game.mu.Lock()
+		game.password = payload.Password
+		game.mu.Unlock()
+		changed = append(changed, "password")
+
+	case "update game":
+		var payload updateGamePayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil {
+			sendError(client, eventName, "invalid payload")
+			return
+		}
+		if payload.Visibility != nil && !validVisibility(*payload.Visibility) {
+			sendError(client, eventName, "visibility must be public, private or unlisted")
+			return
+		}
+
+		game.mu.Lock()
+		if payload.Title != nil {
+			game.title = *payload.Title
+			changed = append(changed, "title")
+		}
+		if payload.GameType != nil {
+			game.gameType = *payload.GameType
+			changed = append(changed, "gameType")
+		}
+		if payload.Visibility != nil {
+			game.visibility = *payload.Visibility
+			changed = append(changed, "visibility")
+		}
+		if payload.Settings != nil {
+			game.settings = payload.Settings
+			changed = append(changed, "settings")
+		}
+		if payload.PauseOnDrop != nil {
+			game.pauseOnDrop = *payload.PauseOnDrop
+			changed = append(changed, "pauseOnDrop")
+		}
+		game.mu.Unlock()
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	client.hub.logger.Info().Strs("changed", changed).Msgf("Game %s updated by %s", game.id, client.userID)
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "game updated",
+		EventPayload: map[string]interface{}{
+			"game":    game.info(),
+			"changed": changed,
+			"userID":  client.userID,
+		},
+	}, "", client.hub.logger)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"sort"
+	"time"
+)
+
+const (
+	VisibilityPublic   = "public"
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+)
+
+const (
+	defaultGamesPageSize = 20
+	maxGamesPageSize     = 100
+)
+
+// GameOptions describe a game when it is created.
+type GameOptions struct {
+	Title      string         `json:"title"`
+	GameType   string         `json:"gameType"`
+	Visibility string         `json:"visibility"`
+	MaxClients int            `json:"capacity"`
+	Settings   map[string]any `json:"settings"`
+	// PauseOnDrop pauses the game in progress when a player disconnects
+	PauseOnDrop bool   `json:"pauseOnDrop"`
+	Seats       []Seat `json:"seats"`
+	// KeepWhenEmpty keeps the game registered after its last client leaves,
+	// so players can reconnect until it expires from inactivity
+	KeepWhenEmpty bool `json:"-"`
+}
+
+// GameInfo is the public view of a game, it never includes the password.
+type GameInfo struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	GameType    string     `json:"gameType"`
+	Visibility  string     `json:"visibility"`
+	Players     int        `json:"players"`
+	Spectators  int        `json:"spectators"`
+	Status      string     `json:"status"`
+	Capacity    int        `json:"capacity"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Owner       string     `json:"owner"`
+	Locked      bool       `json:"locked"`
+	PauseOnDrop bool       `json:"pauseOnDrop"`
+	Seats       []SeatInfo `json:"seats,omitempty"`
+	// Commitment is the hex SHA-256 of the seed behind the next roll or
+	// shuffle
+	Commitment string         `json:"commitment"`
+	Settings   map[string]any `json:"settings,omitempty"`
+}
+
+// GameFilter narrows down the games returned by ListGames.
+type GameFilter struct {
+	GameType     string
+	HasFreeSeats bool
+	Cursor       string
+	Limit        int
+}
+
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityPrivate, VisibilityUnlisted:
+		return true
+	}
+
+	return false
+}
+
+func (game *Game) info() GameInfo {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	info := GameInfo{
+		ID:          game.id,
+		Title:       game.title,
+		GameType:    game.gameType,
+		Visibility:  game.visibility,
+		Players:     len(game.players()),
+		Spectators:  len(game.clients) - len(game.players()),
+		Status:      game.status,
+		Capacity:    game.maxClients,
+		CreatedAt:   game.createdAt,
+		Settings:    game.settings,
+		Locked:      game.locked,
+		PauseOnDrop: game.pauseOnDrop,
+		Seats:       game.seatInfo(),
+		Commitment:  game.rng.commitment(),
+	}
+	if game.owner != nil {
+		info.Owner = game.owner.username
+	}
+
+	return info
+}
+
+func (game *Game) checkPassword(password string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.password == password
+}
+
+func (game *Game) isFull() bool {
+	return game.isFullFor("")
+}
+
+// isFullFor reports whether the game has no room for the session, not
+// counting a connection the session still holds, so a player reconnecting
+// before their old connection timed out gets their place back.
+func (game *Game) isFullFor(sessionID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	players := 0
+	for _, client := range game.players() {
+		if sessionID == "" || client.sessionID != sessionID {
+			players++
+		}
+	}
+
+	return players >= game.maxClients
+}
+
+// FindGame returns the game with the given id, or nil.
+func (hub *Hub) FindGame(gameID string) *Game {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for game := range hub.games {
+		if game.id == gameID {
+			return game
+		}
+	}
+
+	return nil
+}
+
+// ListGames returns a page of public games ordered by id, along with the
+// cursor for the next page, which is empty on the last page.
+func (hub *Hub) ListGames(filter GameFilter) ([]GameInfo, string) {
+	games := hub.gameList()
+
+	after := decodeGamesCursor(filter.Cursor)
+	limit := filter.Limit
+	if limit <= 0 || limit > maxGamesPageSize {
+		limit = defaultGamesPageSize
+	}
+
+	var infos []GameInfo
+	for _, game := range games {
+		info := game.info()
+		if info.Visibility != VisibilityPublic || info.ID <= after {
+			continue
+		}
+		if filter.GameType != "" && info.GameType != filter.GameType {
+			continue
+		}
+		if filter.HasFreeSeats && info.Players >= info.Capacity {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	if len(infos) <= limit {
+		return infos, ""
+	}
+	infos = infos[:limit]
+
+	return infos, encodeGamesCursor(infos[limit-1].ID)
+}
+
+func encodeGamesCursor(gameID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(gameID))
+}
+
+func decodeGamesCursor(cursor string) string {
+	gameID, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ""
+	}
+
+	return string(gameID)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import "time"
+
+// stateVersion is one synced state kept in the game's history.
+type stateVersion struct {
+	version int
+	state   interface{}
+	userID  string
+	savedAt time.Time
+}
+
+type restorePayload struct {
+	Version int `json:"version"`
+}
+
+// recordState makes state the game's current state and adds it to the
+// history, dropping any redo steps and the oldest versions past the limit.
+// game.mu must be held.
+func (game *Game) recordState(state interface{}, userID string, limit int) {
+	game.state = state
+
+	version := 1
+	if n := len(game.history); n > 0 {
+		version = game.history[n-1].version + 1
+	}
+	game.history = append(game.history[:game.historyAt+1], stateVersion{
+		version: version,
+		state:   state,
+		userID:  userID,
+		savedAt: time.Now(),
+	})
+	if len(game.history) > limit {
+		game.history = game.history[len(game.history)-limit:]
+	}
+	game.historyAt = len(game.history) - 1
+}
+
+// handleHistoryEvents lets the owner step back and forward through the
+// synced states or restore a given version. The chosen state is sent to
+// every client as a hydrate response.
+func handleHistoryEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+	game := client.game
+
+	var payload restorePayload
+	if eventName == "restore" {
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.Version <= 0 {
+			sendError(client, eventName, "version is required")
+			return
+		}
+	}
+
+	game.mu.Lock()
+	at := game.historyAt
+	switch eventName {
+	case "undo":
+		at--
+	case "redo":
+		at++
+	case "restore":
+		at = -1
+		for i, v := range game.history {
+			if v.version == payload.Version {
+				at = i
+			}
+		}
+	}
+	if at < 0 || at >= len(game.history) {
+		game.mu.Unlock()
+		switch eventName {
+		case "undo":
+			sendError(client, eventName, "nothing to undo")
+		case "redo":
+			sendError(client, eventName, "nothing to redo")
+		default:
+			sendError(client, eventName, "version not found")
+		}
+		return
+	}
+	game.historyAt = at
+	chosen := game.history[at]
+	game.state = chosen.state
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "hydrate response",
+		EventPayload: map[string]interface{}{
+			"message": chosen.state,
+		},
+	}, "", client.hub.logger)
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "state restored",
+		EventPayload: map[string]interface{}{
+			"userID":   client.userID,
+			"action":   eventName,
+			"version":  chosen.version,
+			"syncedBy": chosen.userID,
+			"syncedAt": millis(chosen.savedAt),
+		},
+	}, "", client.hub.logger)
+}
@@ -1,34 +1,114 @@
 package handlers
 
 import (
+	"context"
+	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
+	"scribe-backend/config"
 	"sync"
+	"time"
 )
 
 type Hub struct {
 	register   chan *Client
 	unregister chan *Client
-	logger     zerolog.Logger
+	remove     chan removal
+	drain      chan struct{}
+	ping       chan chan struct{}
+	// done is closed when the hub starts shutting down
+	done     chan struct{}
+	logger   zerolog.Logger
+	config   config.Config
+	store    GameStore
+	eventLog EventLog
+	// recorder writes to eventLog, it is nil when event logging is off
+	recorder *eventRecorder
+	// chatFilter checks chat messages, they are sent as is when it is nil
+	chatFilter ChatFilter
+	// notifier is told about lifecycle events, nothing is sent when it is nil
+	notifier   Notifier
+	matchmaker *matchmaker
+	// bots are the bots the owner can add by name, guarded by mu
+	bots map[string]BotFactory
 
-	mu    sync.Mutex
-	games map[*Game]bool
+	mu       sync.Mutex
+	games    map[*Game]bool
+	draining bool
+
+	// pumps counts running writePumps so Shutdown can wait for them to flush
+	pumps sync.WaitGroup
 }
 
-func NewHub(logger zerolog.Logger) *Hub {
-	return &Hub{
+func NewHub(logger zerolog.Logger, cfg config.Config, store GameStore, eventLog EventLog, chatFilter ChatFilter, notifier Notifier) *Hub {
+	hub := &Hub{
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		remove:     make(chan removal),
+		drain:      make(chan struct{}),
+		ping:       make(chan chan struct{}),
+		done:       make(chan struct{}),
 		games:      make(map[*Game]bool),
 		logger:     logger,
+		config:     cfg,
+		store:      store,
+		eventLog:   eventLog,
+		chatFilter: chatFilter,
+		notifier:   notifier,
+	}
+	hub.matchmaker = newMatchmaker(hub)
+	if eventLog != nil {
+		hub.recorder = newEventRecorder(eventLog, logger)
 	}
+	hub.bots = map[string]BotFactory{
+		"pass": func() Bot { return passBot{} },
+	}
+
+	return hub
 }
 
 func RegisterGame(hub *Hub, game *Game) {
 	hub.logger.Info().Msgf("Registering game %s", game.id)
 	hub.games[game] = true
+	activeGames.Inc()
+
+	hub.notify("game created", map[string]interface{}{
+		"gameID":     game.id,
+		"title":      game.title,
+		"gameType":   game.gameType,
+		"visibility": game.visibility,
+		"maxClients": game.maxClients,
+	})
+}
+
+// UnregisterGame removes the game from the hub. reason says why, "empty"
+// when the last client left, "expired" when the janitor removed it or
+// "cancelled" when a match was called off.
+func UnregisterGame(hub *Hub, game *Game, reason string) {
+	hub.logger.Info().Msgf("Unregistering game %s", game.id)
+	delete(hub.games, game)
+	activeGames.Dec()
+	game.stopTimers()
+	game.stopPolls()
+
+	game.mu.Lock()
+	status := game.status
+	game.mu.Unlock()
+	if status == StateInProgress || status == StatePaused {
+		hub.notify("game abandoned", map[string]interface{}{"gameID": game.id, "reason": reason})
+	}
+	hub.notify("game deleted", map[string]interface{}{"gameID": game.id, "reason": reason})
+
+	if hub.recorder != nil {
+		hub.recorder.close(game.recordingID)
+	}
 }
 
 func (hub *Hub) Run() {
+	janitor := time.NewTicker(hub.config.JanitorInterval.Duration())
+	defer janitor.Stop()
+	clocks := time.NewTicker(timerTickInterval)
+	defer clocks.Stop()
+
 	for {
 		select {
 		case client := <-hub.register:
@@ -36,15 +116,170 @@ func (hub *Hub) Run() {
 
 		case client := <-hub.unregister:
 			HandleUserDisconnectEvent(hub, client)
+
+		case r := <-hub.remove:
+			closeClient(r.client, r.code, r.reason)
+
+		case <-hub.drain:
+			HandleServerShutdownEvent(hub)
+
+		case reply := <-hub.ping:
+			close(reply)
+
+		case <-janitor.C:
+			expireIdleGames(hub)
+
+		case <-clocks.C:
+			tickTimers(hub)
+			// waiting players' rating windows widen over time
+			go hub.matchmaker.match()
 		}
 	}
 }
 
-func (hub *Hub) GetGameNames() []string {
-	var gameNames []string
+// gameList returns the registered games so they can be iterated without
+// holding the lock.
+func (hub *Hub) gameList() []*Game {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	games := make([]*Game, 0, len(hub.games))
 	for game := range hub.games {
-		gameNames = append(gameNames, game.id)
+		games = append(games, game)
+	}
+
+	return games
+}
+
+func (hub *Hub) IsDraining() bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	return hub.draining
+}
+
+// Ping checks that the hub goroutine is still processing events.
+func (hub *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+
+	select {
+	case hub.ping <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trackPump reserves a slot for a new writePump, refusing once the hub drains.
+func (hub *Hub) trackPump() bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.draining {
+		return false
+	}
+	hub.pumps.Add(1)
+
+	return true
+}
+
+// Shutdown stops the hub accepting new clients, tells every connected client
+// the server is going away and waits until their writePumps have flushed or
+// ctx is done.
+func (hub *Hub) Shutdown(ctx context.Context) error {
+	hub.mu.Lock()
+	if !hub.draining {
+		hub.draining = true
+		close(hub.done)
+	}
+	hub.mu.Unlock()
+
+	select {
+	case hub.drain <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		hub.pumps.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if hub.recorder == nil {
+		return nil
+	}
+
+	return hub.recorder.flush(ctx)
+}
+
+// HandleServerShutdownEvent warns and closes every client, then persists the
+// games if a store is configured.
+func HandleServerShutdownEvent(hub *Hub) {
+	event := SocketEventStruct{
+		EventName: "server shutting down",
+		EventPayload: map[string]interface{}{
+			"reconnectAfterMs": hub.config.ReconnectHint.Duration().Milliseconds(),
+		},
 	}
 
-	return gameNames
+	for _, game := range hub.gameList() {
+		for _, client := range game.clientList() {
+			client.enqueue(event)
+			closeClient(client, websocket.CloseGoingAway, "server shutting down")
+		}
+
+		if hub.store == nil {
+			continue
+		}
+		if err := hub.store.SaveGame(game.snapshot()); err != nil {
+			hub.logger.Error().Err(err).Msgf("Error saving snapshot for game %s", game.id)
+		}
+	}
+}
+
+// closeClient removes client from its game and closes its send channel, so
+// writePump flushes what is queued and ends with a close frame carrying code
+// and reason. It must only be called from the hub goroutine.
+func closeClient(client *Client, code int, reason string) {
+	client.game.mu.Lock()
+	if client.game.clients[client] {
+		delete(client.game.clients, client)
+		connectedClients.WithLabelValues(clientRole(client)).Dec()
+		defer client.hub.notify("player left", map[string]interface{}{
+			"gameID": client.game.id,
+			"userID": client.userID,
+			"reason": reason,
+		})
+	}
+	client.game.mu.Unlock()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.closeSend(code, reason)
+}
+
+// closeSend closes the client's send channel, so writePump ends with a close
+// frame carrying code and reason. client.mu must be held.
+func (client *Client) closeSend(code int, reason string) {
+	if client.closed {
+		return
+	}
+	client.closed = true
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.send)
 }
@@ -0,0 +1,78 @@
+package handlers
+
+import "time"
+
+// closeExpired is sent to clients of a game that expired from inactivity.
+const closeExpired = 4002
+
+// touch records activity in the game, postponing its expiry.
+func (game *Game) touch() {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	game.lastActivity = time.Now()
+	game.idleWarned = false
+}
+
+// expireIdleGames warns players of games that are about to expire and removes
+// games that have had no activity for the idle timeout, along with their
+// snapshots. It runs on the hub goroutine.
+func expireIdleGames(hub *Hub) {
+	now := time.Now()
+	idleTimeout := hub.config.IdleTimeout.Duration()
+	idleWarning := hub.config.IdleWarning.Duration()
+
+	for _, game := range hub.gameList() {
+		game.mu.Lock()
+		expiresAt := game.lastActivity.Add(idleTimeout)
+		warn := !game.idleWarned && now.After(expiresAt.Add(-idleWarning))
+		if warn {
+			game.idleWarned = true
+		}
+		game.mu.Unlock()
+
+		if now.After(expiresAt) {
+			expireGame(hub, game)
+			continue
+		}
+
+		if warn {
+			EmitToConnectedClients(game, SocketEventStruct{
+				EventName: "idle warning",
+				EventPayload: map[string]interface{}{
+					"expiresAt": expiresAt,
+				},
+			}, "", hub.logger)
+		}
+	}
+
+	if hub.store == nil {
+		return
+	}
+	pruned, err := hub.store.PruneGames(now.Add(-idleTimeout))
+	if err != nil {
+		hub.logger.Error().Err(err).Msg("Error pruning stale snapshots")
+	}
+	if pruned > 0 {
+		hub.logger.Info().Msgf("Pruned %d stale snapshots", pruned)
+	}
+}
+
+func expireGame(hub *Hub, game *Game) {
+	hub.logger.Info().Msgf("Expiring idle game %s", game.id)
+
+	for _, client := range game.clientList() {
+		closeClient(client, closeExpired, "game expired due to inactivity")
+	}
+
+	hub.mu.Lock()
+	UnregisterGame(hub, game, "expired")
+	hub.mu.Unlock()
+
+	if hub.store == nil {
+		return
+	}
+	if err := hub.store.DeleteGame(game.id); err != nil {
+		hub.logger.Error().Err(err).Msgf("Error deleting snapshot for game %s", game.id)
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import "time"
+
+// Game states. Games start in the lobby and the owner moves them along,
+// except for the ready check which starts the game by itself once every
+// player is ready.
+const (
+	StateLobby      = "lobby"
+	StateReadyCheck = "ready-check"
+	StateInProgress = "in-progress"
+	StatePaused     = "paused"
+	StateFinished   = "finished"
+)
+
+// stateTransitions lists the states each owner event moves the game from,
+// and the state it moves it to.
+var stateTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	"start ready check":  {from: []string{StateLobby}, to: StateReadyCheck},
+	"cancel ready check": {from: []string{StateReadyCheck}, to: StateLobby},
+	"start game":         {from: []string{StateLobby, StateReadyCheck}, to: StateInProgress},
+	"pause game":         {from: []string{StateInProgress}, to: StatePaused},
+	"resume game":        {from: []string{StatePaused}, to: StateInProgress},
+	"finish game":        {from: []string{StateInProgress, StatePaused}, to: StateFinished},
+	"reopen game":        {from: []string{StateFinished}, to: StateLobby},
+}
+
+// spectatorEvents are the only events spectators may send.
+var spectatorEvents = map[string]bool{
+	"join":        true,
+	"disconnect":  true,
+	"chat":        true,
+	"chat edit":   true,
+	"chat delete": true,
+	"time sync":   true,
+}
+
+// canPlay reports whether a new or returning session may join the game as
+// a player, spectators can't take a seat once the game started.
+func (game *Game) canPlay(sessionID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	started := game.status == StateInProgress || game.status == StatePaused
+	_, returning := game.sessions[sessionID]
+
+	return !started || (returning && !game.spectating[sessionID])
+}
+
+// players returns the connected clients that aren't spectating. game.mu must
+// be held.
+func (game *Game) players() []*Client {
+	players := make([]*Client, 0, len(game.clients))
+	for client := range game.clients {
+		if !client.spectator {
+			players = append(players, client)
+		}
+	}
+
+	return players
+}
+
+// setStatus moves the game to status, resetting the ready check. It returns
+// the payload announcing the change. game.mu must be held.
+func (game *Game) setStatus(status string, userID string) map[string]interface{} {
+	previous := game.status
+	game.status = status
+	game.ready = make(map[string]bool)
+
+	now := time.Now()
+	if status == StatePaused {
+		game.freezeTimers(now)
+	} else {
+		game.stopDropTimer()
+	}
+	if previous == StatePaused && status == StateInProgress {
+		game.thawTimers(now)
+	}
+
+	switch {
+	case status == StateInProgress && previous != StatePaused:
+		game.hub.notify("game started", map[string]interface{}{"gameID": game.id, "players": len(game.players())})
+	case status == StateFinished:
+		game.hub.notify("game finished", map[string]interface{}{"gameID": game.id})
+	}
+
+	payload := map[string]interface{}{
+		"status":   status,
+		"previous": previous,
+		"userID":   nil,
+	}
+	if userID != "" {
+		payload["userID"] = userID
+	}
+
+	return payload
+}
+
+// readyPayload describes the ready check. game.mu must be held.
+func (game *Game) readyPayload() map[string]interface{} {
+	ready := []string{}
+	players := game.players()
+	for _, player := range players {
+		if game.ready[player.userID] {
+			ready = append(ready, player.userID)
+		}
+	}
+
+	return map[string]interface{}{
+		"ready":   ready,
+		"players": len(players),
+	}
+}
+
+// startIfAllReady starts the game once every connected player is ready. It
+// returns the state change to announce, or nil. game.mu must be held.
+func (game *Game) startIfAllReady() map[string]interface{} {
+	players := game.players()
+	if game.status != StateReadyCheck || len(players) == 0 {
+		return nil
+	}
+	for _, player := range players {
+		if !game.ready[player.userID] {
+			return nil
+		}
+	}
+
+	return game.setStatus(StateInProgress, "")
+}
+
+func announceStatus(game *Game, payload map[string]interface{}, hub *Hub) {
+	if payload == nil {
+		return
+	}
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "game state changed",
+		EventPayload: payload,
+	}, "", hub.logger)
+
+	game.mu.Lock()
+	hasTimers := len(game.timers) > 0
+	game.mu.Unlock()
+	if hasTimers {
+		broadcastTimers(game, hub)
+	}
+}
+
+// checkReady re-runs the ready check after a player left, the rest may all
+// be ready now.
+func checkReady(game *Game, hub *Hub) {
+	game.mu.Lock()
+	changed := game.startIfAllReady()
+	game.mu.Unlock()
+
+	announceStatus(game, changed, hub)
+}
+
+// handleGameStateEvents handles the owner's state transitions.
+func handleGameStateEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+	game := client.game
+	transition := stateTransitions[eventName]
+
+	game.mu.Lock()
+	allowed := false
+	for _, from := range transition.from {
+		allowed = allowed || game.status == from
+	}
+	if !allowed {
+		status := game.status
+		game.mu.Unlock()
+		sendError(client, eventName, "not possible while the game is "+status)
+		return
+	}
+	payload := game.setStatus(transition.to, client.userID)
+	game.mu.Unlock()
+
+	announceStatus(game, payload, client.hub)
+}
+
+// handleReadyEvents lets players mark themselves ready or not during a ready
+// check.
+func handleReadyEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	game.mu.Lock()
+	if game.status != StateReadyCheck {
+		game.mu.Unlock()
+		sendError(client, eventName, "there is no ready check")
+		return
+	}
+	game.ready[client.userID] = eventName == "ready"
+	payload := game.readyPayload()
+	changed := game.startIfAllReady()
+	game.mu.Unlock()
+
+	payload["userID"] = client.userID
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "ready changed",
+		EventPayload: payload,
+	}, "", client.hub.logger)
+	announceStatus(game, changed, client.hub)
+}
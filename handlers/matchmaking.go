@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultRating is used for players who don't send a rating
+	defaultRating = 1000
+	// matchRatingWindow is how far apart the ratings in a match may be at
+	// first, it widens by matchRatingWiden every matchWidenInterval a player
+	// waits
+	matchRatingWindow  = 100
+	matchRatingWiden   = 50
+	matchWidenInterval = 10 * time.Second
+	// matchJoinTimeout is how long the join tokens of a match are valid
+	matchJoinTimeout = time.Minute
+)
+
+type queueKey struct {
+	gameType string
+	players  int
+}
+
+// ticket is a player waiting in the matchmaking queue.
+type ticket struct {
+	key      queueKey
+	rating   float64
+	joinedAt time.Time
+	// found receives the match once the player is grouped
+	found chan matchResult
+}
+
+type matchResult struct {
+	game    string
+	token   string
+	players int
+	*matchProposal
+}
+
+// matchProposal makes sure every player of a match is still connected
+// before any of them is told about it. Each player's waiter reports on
+// confirm, then waits for decided.
+type matchProposal struct {
+	confirm chan bool
+	decided chan struct{}
+	// cancelled is set before decided is closed when someone had left, the
+	// players who are still there go back in the queue
+	cancelled bool
+}
+
+// matchmaker groups queued players into games.
+type matchmaker struct {
+	hub *Hub
+
+	mu     sync.Mutex
+	queues map[queueKey][]*ticket
+}
+
+func newMatchmaker(hub *Hub) *matchmaker {
+	return &matchmaker{hub: hub, queues: make(map[queueKey][]*ticket)}
+}
+
+func (mm *matchmaker) enqueue(t *ticket) int {
+	mm.mu.Lock()
+	mm.queues[t.key] = append(mm.queues[t.key], t)
+	queued := len(mm.queues[t.key])
+	mm.mu.Unlock()
+
+	mm.match()
+	return queued
+}
+
+// leave takes the ticket out of the queue. A ticket that was already
+// matched turns the match down, so the other players don't wait for it.
+func (mm *matchmaker) leave(t *ticket) {
+	mm.mu.Lock()
+	queue := mm.queues[t.key]
+	queued := false
+	for i, q := range queue {
+		if q == t {
+			mm.queues[t.key] = append(queue[:i], queue[i+1:]...)
+			queued = true
+			break
+		}
+	}
+	if len(mm.queues[t.key]) == 0 {
+		delete(mm.queues, t.key)
+	}
+	mm.mu.Unlock()
+
+	if queued {
+		return
+	}
+	select {
+	case match := <-t.found:
+		match.confirm <- false
+	case <-time.After(mm.hub.config.WriteWait.Duration()):
+	}
+}
+
+// window is how far apart the ratings of the ticket's match may be.
+func (t *ticket) window(now time.Time) float64 {
+	return matchRatingWindow + matchRatingWiden*float64(now.Sub(t.joinedAt)/matchWidenInterval)
+}
+
+// match groups every queue into as many games as it can. Within a queue the
+// players are sorted by rating and the closest group whose spread fits the
+// window of all its players is matched first.
+func (mm *matchmaker) match() {
+	now := time.Now()
+
+	mm.mu.Lock()
+	var groups [][]*ticket
+	for key, queue := range mm.queues {
+		for len(queue) >= key.players {
+			sort.SliceStable(queue, func(i, j int) bool { return queue[i].rating < queue[j].rating })
+
+			best := -1
+			bestSpread := 0.0
+			for i := 0; i+key.players <= len(queue); i++ {
+				group := queue[i : i+key.players]
+				spread := group[len(group)-1].rating - group[0].rating
+				fits := true
+				for _, t := range group {
+					fits = fits && spread <= t.window(now)
+				}
+				if fits && (best < 0 || spread < bestSpread) {
+					best, bestSpread = i, spread
+				}
+			}
+			if best < 0 {
+				break
+			}
+
+			groups = append(groups, append([]*ticket{}, queue[best:best+key.players]...))
+			queue = append(queue[:best], queue[best+key.players:]...)
+		}
+
+		if len(queue) == 0 {
+			delete(mm.queues, key)
+		} else {
+			mm.queues[key] = queue
+		}
+	}
+	mm.mu.Unlock()
+
+	// start waits on the players' waiters, and match may run on one of them
+	for _, group := range groups {
+		go mm.start(group)
+	}
+}
+
+// requeue puts a matched player back in the queue, ahead of newer players.
+func (mm *matchmaker) requeue(t *ticket) {
+	mm.mu.Lock()
+	mm.queues[t.key] = append([]*ticket{t}, mm.queues[t.key]...)
+	mm.mu.Unlock()
+}
+
+// start creates a game for the group and hands each player a single use
+// invite to it. Players are put back in the queue when that fails, and the
+// game is called off if a player left in the meantime.
+func (mm *matchmaker) start(group []*ticket) {
+	key := group[0].key
+	password, err := newInviteToken()
+	var game *Game
+	if err == nil {
+		game = CreateGameWithCode(mm.hub, password, GameOptions{
+			GameType:      key.gameType,
+			Visibility:    VisibilityPrivate,
+			MaxClients:    key.players,
+			KeepWhenEmpty: true,
+		})
+		if game == nil {
+			err = errors.New("could not create game")
+		}
+	}
+
+	var tokens []string
+	for range group {
+		if game == nil {
+			break
+		}
+		var token string
+		if token, err = newInviteToken(); err != nil {
+			game = nil
+			break
+		}
+		tokens = append(tokens, token)
+	}
+	if game == nil {
+		mm.hub.logger.Error().Err(err).Msgf("Error creating %s match", key.gameType)
+		mm.mu.Lock()
+		mm.queues[key] = append(group, mm.queues[key]...)
+		mm.mu.Unlock()
+		return
+	}
+
+	expiresAt := time.Now().Add(matchJoinTimeout)
+	game.mu.Lock()
+	for _, token := range tokens {
+		game.invites[token] = &invite{singleUse: true, expiresAt: expiresAt}
+	}
+	game.mu.Unlock()
+
+	proposal := &matchProposal{confirm: make(chan bool, len(group)), decided: make(chan struct{})}
+	for i, t := range group {
+		t.found <- matchResult{game: game.id, token: tokens[i], players: key.players, matchProposal: proposal}
+	}
+
+	// waiters that already returned never answer
+	confirmed := 0
+	timeout := time.NewTimer(mm.hub.config.WriteWait.Duration())
+	defer timeout.Stop()
+collect:
+	for range group {
+		select {
+		case ok := <-proposal.confirm:
+			if !ok {
+				break collect
+			}
+			confirmed++
+		case <-timeout.C:
+			break collect
+		}
+	}
+
+	if confirmed < len(group) {
+		mm.hub.logger.Info().Msgf("Calling off match %s, a player left", game.id)
+		mm.hub.mu.Lock()
+		UnregisterGame(mm.hub, game, "cancelled")
+		mm.hub.mu.Unlock()
+		proposal.cancelled = true
+		close(proposal.decided)
+		return
+	}
+
+	mm.hub.logger.Info().Msgf("Matched %d players into game %s", len(group), game.id)
+	close(proposal.decided)
+}
+
+// MatchmakingConnection queues the player for a game of the gameType query
+// parameter with the given number of players and optional rating. The
+// player gets "match found" with a join code and invite token once grouped,
+// after which the connection is closed.
+func (ep Endpoint) MatchmakingConnection(w http.ResponseWriter, r *http.Request) {
+	if ep.hub.IsDraining() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	t := &ticket{
+		key:      queueKey{gameType: query.Get("gameType"), players: 2},
+		rating:   defaultRating,
+		joinedAt: time.Now(),
+		found:    make(chan matchResult, 1),
+	}
+	if t.key.gameType == "" {
+		http.Error(w, "gameType is required", http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("players"); v != "" {
+		players, err := strconv.Atoi(v)
+		if err != nil || players < 2 || players > ep.config.MaxClients {
+			http.Error(w, fmt.Sprintf("players must be between 2 and %d", ep.config.MaxClients), http.StatusBadRequest)
+			return
+		}
+		t.key.players = players
+	}
+	if v := query.Get("rating"); v != "" {
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(rating) || math.IsInf(rating, 0) {
+			http.Error(w, "rating must be a number", http.StatusBadRequest)
+			return
+		}
+		t.rating = rating
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  ep.config.ReadBufferSize,
+		WriteBufferSize: ep.config.WriteBufferSize,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ep.logger.Error().Msgf("Error upgrading connection: %s", err)
+		upgradeFailures.Inc()
+		return
+	}
+
+	if !ep.hub.trackPump() {
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(ep.config.WriteWait.Duration()))
+		ws.Close()
+		return
+	}
+
+	go ep.hub.matchmaker.wait(ws, t)
+}
+
+// wait keeps the player in the queue until they are matched, they close the
+// connection or the server shuts down.
+func (mm *matchmaker) wait(conn *websocket.Conn, t *ticket) {
+	defer func() {
+		conn.Close()
+		mm.hub.pumps.Done()
+	}()
+
+	writeWait := mm.hub.config.WriteWait.Duration()
+	write := func(messageType int, payload []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteMessage(messageType, payload) == nil
+	}
+	writeEvent := func(event SocketEventStruct) bool {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return false
+		}
+		bytesOut.Add(float64(len(payload)))
+		return write(websocket.TextMessage, payload)
+	}
+
+	// like readPump, a player who stops answering pings leaves the queue
+	pongWait := mm.hub.config.PongWait.Duration()
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	queued := mm.enqueue(t)
+	if !writeEvent(SocketEventStruct{
+		EventName: "queued",
+		EventPayload: map[string]interface{}{
+			"gameType": t.key.gameType,
+			"players":  t.key.players,
+			"rating":   t.rating,
+			"queued":   queued,
+		},
+	}) {
+		mm.leave(t)
+		return
+	}
+
+	ping := time.NewTicker(mm.hub.config.PingPeriod())
+	defer ping.Stop()
+
+	for {
+		select {
+		case match := <-t.found:
+			// a ping shows the connection still works before anyone is
+			// sent to the game
+			select {
+			case <-closed:
+				match.confirm <- false
+				return
+			default:
+			}
+			if !write(websocket.PingMessage, nil) {
+				match.confirm <- false
+				return
+			}
+			match.confirm <- true
+
+			<-match.decided
+			if match.cancelled {
+				mm.requeue(t)
+				continue
+			}
+
+			writeEvent(SocketEventStruct{
+				EventName: "match found",
+				EventPayload: map[string]interface{}{
+					"joinCode":  match.game,
+					"joinToken": match.token,
+					"gameType":  t.key.gameType,
+					"players":   match.players,
+				},
+			})
+			write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "match found"))
+			return
+
+		case <-ping.C:
+			if !write(websocket.PingMessage, nil) {
+				mm.leave(t)
+				return
+			}
+
+		case <-closed:
+			mm.leave(t)
+			return
+
+		case <-mm.hub.done:
+			mm.leave(t)
+			write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			return
+		}
+	}
+}
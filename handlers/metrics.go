@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	roleOwner     = "owner"
+	rolePlayer    = "player"
+	roleSpectator = "spectator"
+)
+
+var (
+	activeGames = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "scribe_active_games",
+		Help: "Number of games currently registered in the hub.",
+	})
+	connectedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scribe_connected_clients",
+		Help: "Number of connected clients by role.",
+	}, []string{"role"})
+	eventsIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scribe_events_in_total",
+		Help: "Socket events received from clients by event name.",
+	}, []string{"event"})
+	eventsOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scribe_events_out_total",
+		Help: "Socket events written to clients by event name.",
+	}, []string{"event"})
+	bytesIn = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "scribe_bytes_in_total",
+		Help: "Bytes received from clients.",
+	})
+	bytesOut = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "scribe_bytes_out_total",
+		Help: "Bytes written to clients.",
+	})
+	broadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "scribe_broadcast_duration_seconds",
+		Help:    "Time taken to fan an event out to every client in a game.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+	outboundQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "scribe_outbound_queue_depth",
+		Help: "Events queued for writePumps that have not been written yet.",
+	})
+	upgradeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "scribe_upgrade_failures_total",
+		Help: "Websocket upgrades that failed.",
+	})
+	authFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "scribe_auth_failures_total",
+		Help: "Connections rejected because of a wrong game password.",
+	})
+	rateLimitRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "scribe_rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter.",
+	})
+)
+
+// socketEvents are the event names clients may send, anything else is
+// counted as unknown so clients can't blow up the metric cardinality.
+var socketEvents = map[string]bool{
+	"sync":       true,
+	"hydrate":    true,
+	"join":       true,
+	"disconnect": true,
+	"message":    true,
+
+	"create invite": true,
+	"kick":          true,
+	"ban":           true,
+
+	"lock":            true,
+	"unlock":          true,
+	"change password": true,
+	"update game":     true,
+
+	"set turn order": true,
+	"clear turns":    true,
+	"pass":           true,
+	"skip":           true,
+	"reverse":        true,
+
+	"timer start":  true,
+	"timer pause":  true,
+	"timer resume": true,
+	"timer add":    true,
+	"timer cancel": true,
+	"time sync":    true,
+
+	"roll":        true,
+	"shuffle":     true,
+	"reveal seed": true,
+
+	"upload deck":  true,
+	"shuffle deck": true,
+	"draw":         true,
+	"deal":         true,
+	"discard":      true,
+	"reveal":       true,
+
+	"undo":    true,
+	"redo":    true,
+	"restore": true,
+
+	"poll create": true,
+	"vote":        true,
+	"poll close":  true,
+
+	"chat":        true,
+	"chat edit":   true,
+	"chat delete": true,
+	"mute":        true,
+	"unmute":      true,
+
+	"start ready check":  true,
+	"cancel ready check": true,
+	"start game":         true,
+	"pause game":         true,
+	"resume game":        true,
+	"finish game":        true,
+	"reopen game":        true,
+	"ready":              true,
+	"unready":            true,
+	"replace player":     true,
+
+	"set seats":  true,
+	"claim seat": true,
+	"leave seat": true,
+	"swap seats": true,
+	"add bot":    true,
+}
+
+func eventLabel(eventName string) string {
+	if socketEvents[eventName] {
+		return eventName
+	}
+
+	return "unknown"
+}
+
+func clientRole(client *Client) string {
+	if client.game.owner == client {
+		return roleOwner
+	}
+	if client.spectator {
+		return roleSpectator
+	}
+
+	return rolePlayer
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import "unicode/utf8"
+
+// Close codes sent to players removed by the owner, in the range reserved
+// for applications.
+const (
+	closeKicked = 4000
+	closeBanned = 4001
+)
+
+// maxReasonLength keeps reasons within what fits in a close frame.
+const maxReasonLength = 120
+
+// removal asks the hub to close a client's connection.
+type removal struct {
+	client *Client
+	code   int
+	reason string
+}
+
+type removePlayerPayload struct {
+	UserID string `json:"userID"`
+	Reason string `json:"reason"`
+}
+
+// isBanned reports whether sessionID was banned. Bans only stick to the
+// session, a player connecting without it gets a fresh identity.
+func (game *Game) isBanned(sessionID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.banned[sessionID]
+}
+
+// handleRemovePlayerEvent handles the owner's kick and ban events. Both close
+// the target's socket, a ban also keeps the target's session from rejoining.
+func handleRemovePlayerEvent(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	if !requireOwner(client, eventName) {
+		return
+	}
+
+	var payload removePlayerPayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.UserID == "" {
+		sendError(client, eventName, "invalid payload")
+		return
+	}
+
+	target := client.game.findClient(payload.UserID)
+	if target == nil {
+		sendError(client, eventName, "player not found")
+		return
+	}
+	if target == client {
+		sendError(client, eventName, "the owner can't remove themselves")
+		return
+	}
+
+	banned := eventName == "ban"
+	code := closeKicked
+	reason := "kicked by the owner"
+	if banned {
+		code = closeBanned
+		reason = "banned by the owner"
+
+		client.game.mu.Lock()
+		client.game.banned[target.sessionID] = true
+		client.game.mu.Unlock()
+	}
+	if payload.Reason != "" {
+		reason = truncate(payload.Reason, maxReasonLength)
+	}
+
+	client.hub.logger.Info().Msgf("Removing client %s from game %s: %s", target.userID, client.game.id, reason)
+	client.hub.remove <- removal{client: target, code: code, reason: reason}
+
+	EmitToConnectedClients(client.game, SocketEventStruct{
+		EventName: "player removed",
+		EventPayload: map[string]interface{}{
+			"userID": target.userID,
+			"reason": reason,
+			"banned": banned,
+		},
+	}, target.userID, client.hub.logger)
+}
+
+// truncate shortens s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	maxPollOptions = 10
+	// maxPollDuration is also how long polls without a durationMs stay open
+	maxPollDuration = time.Hour
+	// maxOpenPolls and maxOpenPollsPerPlayer cap the polls open at once in a
+	// game and by a single player
+	maxOpenPolls          = 10
+	maxOpenPollsPerPlayer = 2
+)
+
+// Actions a poll can carry out when it passes.
+const (
+	pollActionKick     = "kick"
+	pollActionTransfer = "transfer ownership"
+)
+
+type poll struct {
+	id        string
+	question  string
+	options   []string
+	anonymous bool
+	createdBy string
+	// action polls are yes/no and act on target when they pass
+	action string
+	target string
+
+	votes    map[string]int
+	closesAt time.Time
+	timer    *time.Timer
+	closed   bool
+}
+
+type pollCreatePayload struct {
+	Question   string   `json:"question"`
+	Options    []string `json:"options"`
+	Anonymous  bool     `json:"anonymous"`
+	DurationMs int64    `json:"durationMs"`
+	Action     string   `json:"action"`
+	Target     string   `json:"target"`
+}
+
+type votePayload struct {
+	PollID string `json:"pollID"`
+	Option int    `json:"option"`
+}
+
+// payload describes the poll, only naming voters on open ballots. game.mu
+// must be held.
+func (p *poll) payload() map[string]interface{} {
+	tallies := make([]int, len(p.options))
+	for _, option := range p.votes {
+		tallies[option]++
+	}
+
+	payload := map[string]interface{}{
+		"pollID":    p.id,
+		"question":  p.question,
+		"options":   p.options,
+		"anonymous": p.anonymous,
+		"createdBy": p.createdBy,
+		"tallies":   tallies,
+		"voted":     len(p.votes),
+		"closesAt":  millis(p.closesAt),
+	}
+	if !p.anonymous {
+		votes := make(map[string]int)
+		for userID, option := range p.votes {
+			votes[userID] = option
+		}
+		payload["votes"] = votes
+	}
+	if p.action != "" {
+		payload["action"] = p.action
+		payload["target"] = p.target
+	}
+
+	return payload
+}
+
+// voters are the connected players who may vote, which leaves out the
+// player a kick poll is about. game.mu must be held.
+func (p *poll) voters(game *Game) int {
+	n := 0
+	for _, c := range game.players() {
+		if p.action != pollActionKick || c.userID != p.target {
+			n++
+		}
+	}
+
+	return n
+}
+
+// decided reports whether the poll's result can no longer change: everyone
+// voted, or an action poll already has a majority either way. game.mu must
+// be held.
+func (p *poll) decided(game *Game) bool {
+	voters := p.voters(game)
+	if len(p.votes) >= voters {
+		return true
+	}
+	if p.action == "" {
+		return false
+	}
+
+	yes := 0
+	for _, option := range p.votes {
+		if option == 0 {
+			yes++
+		}
+	}
+
+	return yes*2 > voters || (len(p.votes)-yes)*2 >= voters
+}
+
+// setOwner hands the game to target, moving the role of both clients in the
+// connected clients metric. game.mu must be held.
+func (game *Game) setOwner(target *Client) {
+	if game.owner != nil && game.clients[game.owner] {
+		connectedClients.WithLabelValues(roleOwner).Dec()
+		connectedClients.WithLabelValues(rolePlayer).Inc()
+	}
+	if game.clients[target] {
+		connectedClients.WithLabelValues(rolePlayer).Dec()
+		connectedClients.WithLabelValues(roleOwner).Inc()
+	}
+	previous := ""
+	if game.owner != nil {
+		previous = game.owner.userID
+	}
+	game.owner = target
+
+	game.hub.notify("owner changed", map[string]interface{}{
+		"gameID":         game.id,
+		"userID":         target.userID,
+		"previousUserID": previous,
+	})
+}
+
+// closePoll closes the poll, broadcasts its result and carries out its
+// action when it passed.
+func closePoll(game *Game, p *poll, reason string) {
+	game.mu.Lock()
+	if p.closed {
+		game.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.timer.Stop()
+	delete(game.polls, p.id)
+
+	payload := p.payload()
+	payload["reason"] = reason
+
+	tallies := payload["tallies"].([]int)
+	winners := []int{}
+	best := 1
+	for option, tally := range tallies {
+		if tally > best {
+			best = tally
+			winners = winners[:0]
+		}
+		if tally == best {
+			winners = append(winners, option)
+		}
+	}
+	payload["winners"] = winners
+
+	passed := p.action != "" && tallies[0]*2 > p.voters(game)
+	if p.action != "" {
+		payload["passed"] = passed
+	}
+
+	var target *Client
+	var previousOwner string
+	if passed {
+		for c := range game.clients {
+			if c.userID == p.target {
+				target = c
+			}
+		}
+		if target != nil && p.action == pollActionTransfer {
+			if game.owner != nil {
+				previousOwner = game.owner.userID
+			}
+			game.setOwner(target)
+		}
+	}
+	hub := game.hub
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "poll closed",
+		EventPayload: payload,
+	}, "", hub.logger)
+
+	if target == nil {
+		return
+	}
+
+	switch p.action {
+	case pollActionKick:
+		hub.logger.Info().Msgf("Removing client %s from game %s: voted out", target.userID, game.id)
+		hub.remove <- removal{client: target, code: closeKicked, reason: "voted out"}
+
+		EmitToConnectedClients(game, SocketEventStruct{
+			EventName: "player removed",
+			EventPayload: map[string]interface{}{
+				"userID": target.userID,
+				"reason": "voted out",
+				"banned": false,
+			},
+		}, target.userID, hub.logger)
+
+	case pollActionTransfer:
+		EmitToConnectedClients(game, SocketEventStruct{
+			EventName: "owner changed",
+			EventPayload: map[string]interface{}{
+				"userID":         target.userID,
+				"previousUserID": previousOwner,
+			},
+		}, "", hub.logger)
+	}
+}
+
+// stopPolls stops the timeouts of a game that is going away.
+func (game *Game) stopPolls() {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	for _, p := range game.polls {
+		p.timer.Stop()
+	}
+}
+
+// handlePollEvents handles polls. Any player can create a poll and vote once
+// in each, the poll's creator or the owner can close it early. A poll also
+// closes once its result is decided or its time runs out.
+func handlePollEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	switch eventName {
+	case "poll create":
+		var payload pollCreatePayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.Question == "" {
+			sendError(client, eventName, "question is required")
+			return
+		}
+		if payload.DurationMs < 0 || payload.DurationMs > maxPollDuration.Milliseconds() {
+			sendError(client, eventName, "durationMs must be between 0 and an hour")
+			return
+		}
+		duration := maxPollDuration
+		if payload.DurationMs > 0 {
+			duration = time.Duration(payload.DurationMs) * time.Millisecond
+		}
+
+		switch payload.Action {
+		case "":
+			if len(payload.Options) < 2 || len(payload.Options) > maxPollOptions {
+				sendError(client, eventName, "options must list between 2 and 10 choices")
+				return
+			}
+		case pollActionKick, pollActionTransfer:
+			target := game.findClient(payload.Target)
+			if target == nil || target.isSpectator() {
+				sendError(client, eventName, "target must be a connected player")
+				return
+			}
+			if game.isOwner(target) {
+				sendError(client, eventName, "the poll can't be about the owner")
+				return
+			}
+			payload.Options = []string{"yes", "no"}
+		default:
+			sendError(client, eventName, "unknown action")
+			return
+		}
+
+		options := make([]string, len(payload.Options))
+		for i, option := range payload.Options {
+			options[i] = truncate(option, maxReasonLength)
+		}
+		p := &poll{
+			question:  truncate(payload.Question, maxReasonLength),
+			options:   options,
+			anonymous: payload.Anonymous,
+			createdBy: client.userID,
+			action:    payload.Action,
+			target:    payload.Target,
+			votes:     make(map[string]int),
+		}
+
+		game.mu.Lock()
+		created := 0
+		for _, open := range game.polls {
+			if open.createdBy == client.userID {
+				created++
+			}
+		}
+		if len(game.polls) >= maxOpenPolls || created >= maxOpenPollsPerPlayer {
+			game.mu.Unlock()
+			sendError(client, eventName, "too many open polls")
+			return
+		}
+		game.nextPollID++
+		p.id = "p" + strconv.Itoa(game.nextPollID)
+		p.closesAt = time.Now().Add(duration)
+		p.timer = time.AfterFunc(duration, func() { closePoll(game, p, "timeout") })
+		game.polls[p.id] = p
+		pollPayload := p.payload()
+		game.mu.Unlock()
+
+		EmitToConnectedClients(game, SocketEventStruct{
+			EventName:    "poll updated",
+			EventPayload: pollPayload,
+		}, "", client.hub.logger)
+
+	case "vote", "poll close":
+		var payload votePayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+
+		game.mu.Lock()
+		p := game.polls[payload.PollID]
+		var errMessage string
+		switch {
+		case p == nil:
+			errMessage = "poll not found"
+		case eventName == "poll close":
+			if p.createdBy != client.userID && game.owner != client {
+				errMessage = "only the poll's creator or the owner can close it"
+			}
+		case p.action == pollActionKick && p.target == client.userID:
+			errMessage = "you can't vote on your own removal"
+		case payload.Option < 0 || payload.Option >= len(p.options):
+			errMessage = "option out of range"
+		default:
+			if _, voted := p.votes[client.userID]; voted {
+				errMessage = "you already voted"
+				break
+			}
+			p.votes[client.userID] = payload.Option
+		}
+		if errMessage != "" {
+			game.mu.Unlock()
+			sendError(client, eventName, errMessage)
+			return
+		}
+		decided := p.decided(game)
+		pollPayload := p.payload()
+		game.mu.Unlock()
+
+		if eventName == "poll close" {
+			closePoll(game, p, "closed")
+			return
+		}
+
+		EmitToConnectedClients(game, SocketEventStruct{
+			EventName:    "poll updated",
+			EventPayload: pollPayload,
+		}, "", client.hub.logger)
+		if decided {
+			closePoll(game, p, "decided")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// randomSource draws the game's random numbers from a secret seed so they
+// can be audited once the seed is revealed. The n-th draw reads the stream
+// HMAC-SHA256(seed, "<n>:0"), HMAC-SHA256(seed, "<n>:1"), ... eight bytes
+// at a time as big endian integers, rejecting values that would bias the
+// result.
+type randomSource struct {
+	seed  []byte
+	nonce uint64
+
+	block  []byte
+	blocks uint64
+}
+
+func newRandomSource() (*randomSource, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+
+	return &randomSource{seed: seed}, nil
+}
+
+// commitment is published before any draw so the seed can't be swapped
+// after the fact.
+func (r *randomSource) commitment() string {
+	sum := sha256.Sum256(r.seed)
+	return hex.EncodeToString(sum[:])
+}
+
+// next starts a new draw and returns its nonce.
+func (r *randomSource) next() uint64 {
+	r.nonce++
+	r.block = nil
+	r.blocks = 0
+
+	return r.nonce
+}
+
+func (r *randomSource) uint64() uint64 {
+	if len(r.block) < 8 {
+		mac := hmac.New(sha256.New, r.seed)
+		fmt.Fprintf(mac, "%d:%d", r.nonce, r.blocks)
+		r.block = mac.Sum(nil)
+		r.blocks++
+	}
+
+	v := binary.BigEndian.Uint64(r.block)
+	r.block = r.block[8:]
+
+	return v
+}
+
+// intn returns a uniform integer in [0, n).
+func (r *randomSource) intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if v := r.uint64(); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// shuffle permutes n items with a Fisher-Yates shuffle from the last item
+// down.
+func (r *randomSource) shuffle(n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, r.intn(i+1))
+	}
+}
+
+// commitment returns the commitment to the game's current seed.
+func (game *Game) commitment() string {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.rng.commitment()
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testSeed is the bytes 0 to 31.
+func testSeed() []byte {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+
+	return seed
+}
+
+// auditor follows the audit procedure in the README without using
+// randomSource, so the two can be checked against each other.
+type auditor struct {
+	seed   []byte
+	nonce  uint64
+	values []uint64
+	blocks int
+}
+
+func (a *auditor) intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if len(a.values) == 0 {
+			mac := hmac.New(sha256.New, a.seed)
+			mac.Write([]byte(fmt.Sprintf("%d:%d", a.nonce, a.blocks)))
+			sum := mac.Sum(nil)
+			for i := 0; i < len(sum); i += 8 {
+				a.values = append(a.values, binary.BigEndian.Uint64(sum[i:]))
+			}
+			a.blocks++
+		}
+
+		v := a.values[0]
+		a.values = a.values[1:]
+		if v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+func TestRandomSourceKnownSeed(t *testing.T) {
+	rng := &randomSource{seed: testSeed()}
+
+	if got, want := rng.commitment(), "630dcd2966c4336691125448bbb25b4ff412a49c732db2c8abc1b8581bd710dd"; got != want {
+		t.Errorf("commitment() = %s, want %s", got, want)
+	}
+
+	if nonce := rng.next(); nonce != 1 {
+		t.Fatalf("first nonce = %d, want 1", nonce)
+	}
+	var rolls []int
+	for i := 0; i < 10; i++ {
+		rolls = append(rolls, rng.intn(6)+1)
+	}
+	if want := []int{4, 1, 4, 6, 4, 2, 6, 3, 4, 3}; !reflect.DeepEqual(rolls, want) {
+		t.Errorf("d6 rolls = %v, want %v", rolls, want)
+	}
+
+	if nonce := rng.next(); nonce != 2 {
+		t.Fatalf("second nonce = %d, want 2", nonce)
+	}
+	items := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	rng.shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	if want := []string{"a", "f", "h", "b", "c", "d", "g", "e"}; !reflect.DeepEqual(items, want) {
+		t.Errorf("shuffle = %v, want %v", items, want)
+	}
+}
+
+func TestRandomSourceMatchesAudit(t *testing.T) {
+	sizes := []int{1, 2, 3, 6, 7, 20, 52, 100, 1000, 1 << 40}
+
+	rng := &randomSource{seed: testSeed()}
+	for round := 0; round < 50; round++ {
+		nonce := rng.next()
+		audit := &auditor{seed: testSeed(), nonce: nonce}
+		for _, n := range sizes {
+			if got, want := rng.intn(n), audit.intn(n); got != want {
+				t.Fatalf("nonce %d: intn(%d) = %d, audit says %d", nonce, n, got, want)
+			}
+		}
+	}
+
+	// shuffles swap item i with item intn(i+1) from the last item down
+	nonce := rng.next()
+	got := make([]int, 52)
+	want := make([]int, 52)
+	for i := range got {
+		got[i], want[i] = i, i
+	}
+	rng.shuffle(len(got), func(i, j int) { got[i], got[j] = got[j], got[i] })
+	audit := &auditor{seed: testSeed(), nonce: nonce}
+	for i := len(want) - 1; i > 0; i-- {
+		j := audit.intn(i + 1)
+		want[i], want[j] = want[j], want[i]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shuffle = %v, audit says %v", got, want)
+	}
+}
+
+func TestRandomSourceIntnRange(t *testing.T) {
+	rng := &randomSource{seed: testSeed()}
+	rng.next()
+
+	for _, n := range []int{1, 2, 6, 1000} {
+		counts := make([]int, n)
+		draws := 200 * n
+		for i := 0; i < draws; i++ {
+			v := rng.intn(n)
+			if v < 0 || v >= n {
+				t.Fatalf("intn(%d) = %d", n, v)
+			}
+			counts[v]++
+		}
+		// every value should turn up about 200 times
+		for v, count := range counts {
+			if n > 1 && (count < 100 || count > 300) {
+				t.Errorf("intn(%d) returned %d %d times in %d draws", n, v, count, draws)
+			}
+		}
+	}
+}
+
+func TestRandomSourceShuffleIsPermutation(t *testing.T) {
+	rng := &randomSource{seed: testSeed()}
+
+	for _, n := range []int{0, 1, 2, 10, 1000} {
+		rng.next()
+		items := make([]int, n)
+		for i := range items {
+			items[i] = i
+		}
+		rng.shuffle(n, func(i, j int) { items[i], items[j] = items[j], items[i] })
+
+		seen := make([]bool, n)
+		for _, item := range items {
+			if seen[item] {
+				t.Fatalf("shuffle of %d items repeated %d", n, item)
+			}
+			seen[item] = true
+		}
+	}
+}
+
+func TestRandomSourceNewSeeds(t *testing.T) {
+	a, err := newRandomSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newRandomSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.seed) != 32 || a.commitment() == b.commitment() {
+		t.Errorf("seeds should be 32 random bytes, got %x and %x", a.seed, b.seed)
+	}
+}
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	minReplaySpeed = 0.1
+	maxReplaySpeed = 16
+)
+
+// replayedEvents are the outbound events that make up a recording.
+var replayedEvents = map[string]bool{
+	"hydrate response": true,
+	"message response": true,
+}
+
+type replayControlPayload struct {
+	Speed      float64 `json:"speed"`
+	PositionMs int64   `json:"positionMs"`
+}
+
+// replay plays a recording back to a single connection.
+type replay struct {
+	hub     *Hub
+	conn    *websocket.Conn
+	entries []EventLogEntry
+
+	next   int
+	speed  float64
+	paused bool
+	// remaining is the time left until the next entry while paused
+	remaining time.Duration
+	due       time.Time
+	timer     *time.Timer
+}
+
+// recording is a game's replayed events and the hashed sessions of everyone
+// who took part.
+type recording struct {
+	entries      []EventLogEntry
+	participants map[string]bool
+}
+
+// loadRecording reads a single game's recording and keeps one copy of every
+// replayed event, dropping the duplicates older logs have for each
+// recipient.
+func loadRecording(eventLog EventLog, gameID string) (*recording, error) {
+	var buf bytes.Buffer
+	if err := eventLog.Export(gameID, &buf); err != nil {
+		return nil, err
+	}
+
+	rec := &recording{participants: make(map[string]bool)}
+	seen := make(map[uint64]bool)
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var entry EventLogEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		if entry.Direction == directionMeta && entry.EventName == participantEvent {
+			if payload, ok := entry.EventPayload.(map[string]interface{}); ok {
+				if hash, ok := payload["sessionHash"].(string); ok {
+					rec.participants[hash] = true
+				}
+			}
+			continue
+		}
+		if entry.Direction != directionOut || !replayedEvents[entry.EventName] || seen[entry.EventID] {
+			continue
+		}
+		seen[entry.EventID] = true
+		rec.entries = append(rec.entries, entry)
+	}
+
+	return rec, nil
+}
+
+// canReplay reports whether the session took part in the recorded game.
+func (rec *recording) canReplay(sessionID string) bool {
+	return sessionID != "" && rec.participants[sessionHash(sessionID)]
+}
+
+// ReplayConnection streams a recorded game to the client with its original
+// timing. The client can send pause, resume, speed and seek events to
+// control playback. Recordings are available to admins and to everyone who
+// took part in the game, also after it has ended.
+func (ep Endpoint) ReplayConnection(w http.ResponseWriter, r *http.Request) {
+	if ep.hub.eventLog == nil {
+		http.Error(w, "event logging is disabled", http.StatusNotFound)
+		return
+	}
+
+	recordingID := mux.Vars(r)["recordingId"]
+	isAdmin := ep.isAdmin(r)
+
+	if err := ep.hub.recorder.flush(r.Context()); err != nil {
+		return
+	}
+	rec, err := loadRecording(ep.hub.eventLog, recordingID)
+	if errors.Is(err, ErrNoEventLog) && isAdmin {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && !errors.Is(err, ErrNoEventLog) {
+		ep.logger.Error().Err(err).Msgf("Error loading recording %s", recordingID)
+		http.Error(w, "error loading recording", http.StatusInternalServerError)
+		return
+	}
+	// everyone else finds out whether a recording exists only if they took part
+	if !isAdmin && (rec == nil || !rec.canReplay(r.URL.Query().Get("session"))) {
+		authFailures.Inc()
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	entries := rec.entries
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  ep.config.ReadBufferSize,
+		WriteBufferSize: ep.config.WriteBufferSize,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ep.logger.Error().Msgf("Error upgrading connection: %s", err)
+		upgradeFailures.Inc()
+		return
+	}
+
+	if !ep.hub.trackPump() {
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(ep.config.WriteWait.Duration()))
+		ws.Close()
+		return
+	}
+
+	ep.logger.Info().Msgf("Replaying recording %s", recordingID)
+	rp := &replay{hub: ep.hub, conn: ws, entries: entries, speed: 1}
+	go rp.run()
+}
+
+func (rp *replay) run() {
+	defer func() {
+		rp.conn.Close()
+		rp.hub.pumps.Done()
+	}()
+
+	controls := make(chan SocketEventStruct)
+	closed := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// like readPump, a client that stops answering pings is dropped instead
+	// of holding its slot until TCP gives up
+	pongWait := rp.hub.config.PongWait.Duration()
+	rp.conn.SetReadDeadline(time.Now().Add(pongWait))
+	rp.conn.SetPongHandler(func(string) error { rp.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	ping := time.NewTicker(rp.hub.config.PingPeriod())
+	defer ping.Stop()
+
+	go func() {
+		defer close(closed)
+		for {
+			var event SocketEventStruct
+			if err := rp.conn.ReadJSON(&event); err != nil {
+				return
+			}
+
+			select {
+			case controls <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	rp.timer = time.NewTimer(0)
+	rp.due = time.Now()
+	if !rp.sendStatus("replay started") {
+		return
+	}
+	if len(rp.entries) == 0 && !rp.sendStatus("replay finished") {
+		return
+	}
+
+	for {
+		select {
+		case <-rp.timer.C:
+			if !rp.playNext() {
+				return
+			}
+
+		case event := <-controls:
+			if !rp.handleControl(event) {
+				return
+			}
+
+		case <-ping.C:
+			if !rp.write(websocket.PingMessage, nil) {
+				return
+			}
+
+		case <-closed:
+			return
+
+		case <-rp.hub.done:
+			rp.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			return
+		}
+	}
+}
+
+// playNext sends the next entry and schedules the one after it.
+func (rp *replay) playNext() bool {
+	if rp.next >= len(rp.entries) {
+		return true
+	}
+
+	entry := rp.entries[rp.next]
+	rp.next++
+	if !rp.writeEvent(SocketEventStruct{EventName: entry.EventName, EventPayload: entry.EventPayload}) {
+		return false
+	}
+
+	if rp.next == len(rp.entries) {
+		return rp.sendStatus("replay finished")
+	}
+
+	rp.schedule(rp.scaled(rp.entries[rp.next].Time.Sub(entry.Time)))
+	return true
+}
+
+func (rp *replay) handleControl(event SocketEventStruct) bool {
+	var payload replayControlPayload
+	if err := decodePayload(event.EventPayload, &payload); err != nil {
+		return rp.sendError(event.EventName, "invalid payload")
+	}
+
+	switch event.EventName {
+	case "pause":
+		if !rp.paused {
+			rp.paused = true
+			rp.remaining = time.Until(rp.due)
+			rp.timer.Stop()
+		}
+
+	case "resume":
+		if rp.paused {
+			rp.paused = false
+			rp.schedule(rp.remaining)
+		}
+
+	case "speed":
+		if payload.Speed < minReplaySpeed || payload.Speed > maxReplaySpeed {
+			return rp.sendError(event.EventName, "speed must be between 0.1 and 16")
+		}
+
+		remaining := rp.remaining
+		if !rp.paused {
+			remaining = time.Until(rp.due)
+		}
+		remaining = time.Duration(float64(remaining) * rp.speed / payload.Speed)
+		rp.speed = payload.Speed
+
+		if rp.paused {
+			rp.remaining = remaining
+		} else {
+			rp.schedule(remaining)
+		}
+
+	case "seek":
+		if !rp.seek(time.Duration(payload.PositionMs) * time.Millisecond) {
+			return false
+		}
+
+	default:
+		return rp.sendError(event.EventName, "unknown replay control")
+	}
+
+	return rp.sendStatus("replay status")
+}
+
+// seek moves playback to position, first sending the last hydrate response
+// before it so the client shows the state at that point.
+func (rp *replay) seek(position time.Duration) bool {
+	if len(rp.entries) == 0 {
+		return true
+	}
+
+	target := rp.entries[0].Time.Add(position)
+	rp.next = len(rp.entries)
+	for i, entry := range rp.entries {
+		if !entry.Time.Before(target) {
+			rp.next = i
+			break
+		}
+	}
+
+	for i := rp.next - 1; i >= 0; i-- {
+		if rp.entries[i].EventName != "hydrate response" {
+			continue
+		}
+		entry := rp.entries[i]
+		if !rp.writeEvent(SocketEventStruct{EventName: entry.EventName, EventPayload: entry.EventPayload}) {
+			return false
+		}
+		break
+	}
+
+	wait := time.Duration(0)
+	if rp.next < len(rp.entries) {
+		wait = rp.scaled(rp.entries[rp.next].Time.Sub(target))
+	}
+	if rp.paused {
+		rp.remaining = wait
+	} else {
+		rp.schedule(wait)
+	}
+
+	return true
+}
+
+func (rp *replay) schedule(wait time.Duration) {
+	rp.timer.Stop()
+	select {
+	case <-rp.timer.C:
+	default:
+	}
+
+	rp.due = time.Now().Add(wait)
+	rp.timer.Reset(wait)
+}
+
+func (rp *replay) scaled(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / rp.speed)
+}
+
+// position is how far into the recording playback is.
+func (rp *replay) position() time.Duration {
+	if len(rp.entries) == 0 {
+		return 0
+	}
+	if rp.next >= len(rp.entries) {
+		return rp.duration()
+	}
+
+	remaining := rp.remaining
+	if !rp.paused {
+		remaining = time.Until(rp.due)
+	}
+	position := rp.entries[rp.next].Time.Sub(rp.entries[0].Time) - time.Duration(float64(remaining)*rp.speed)
+	if position < 0 {
+		return 0
+	}
+
+	return position
+}
+
+func (rp *replay) duration() time.Duration {
+	if len(rp.entries) == 0 {
+		return 0
+	}
+
+	return rp.entries[len(rp.entries)-1].Time.Sub(rp.entries[0].Time)
+}
+
+func (rp *replay) sendStatus(eventName string) bool {
+	return rp.writeEvent(SocketEventStruct{
+		EventName: eventName,
+		EventPayload: map[string]interface{}{
+			"positionMs": rp.position().Milliseconds(),
+			"durationMs": rp.duration().Milliseconds(),
+			"speed":      rp.speed,
+			"paused":     rp.paused,
+			"events":     len(rp.entries),
+		},
+	})
+}
+
+func (rp *replay) sendError(eventName string, message string) bool {
+	return rp.writeEvent(SocketEventStruct{
+		EventName: "error",
+		EventPayload: map[string]interface{}{
+			"event":   eventName,
+			"message": message,
+		},
+	})
+}
+
+func (rp *replay) writeEvent(event SocketEventStruct) bool {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		rp.hub.logger.Error().Err(err).Msg("Error encoding replay event")
+		return false
+	}
+
+	return rp.write(websocket.TextMessage, payload)
+}
+
+func (rp *replay) write(messageType int, payload []byte) bool {
+	rp.conn.SetWriteDeadline(time.Now().Add(rp.hub.config.WriteWait.Duration()))
+	if err := rp.conn.WriteMessage(messageType, payload); err != nil {
+		return false
+	}
+
+	if messageType == websocket.TextMessage {
+		bytesOut.Add(float64(len(payload)))
+	}
+	return true
+}
@@ -1,47 +1,249 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"scribe-backend/config"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/didip/tollbooth/v7"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 )
 
-func Routes(r *mux.Router, logger zerolog.Logger) http.Handler {
-	hub := NewHub(logger)
-	go hub.Run()
-	ep := Endpoint{hub: hub, logger: logger}
+func Routes(r *mux.Router, hub *Hub, cfg config.Config, logger zerolog.Logger) http.Handler {
+	ep := Endpoint{hub: hub, config: cfg, logger: logger}
 
 	wsRouter := r.PathPrefix("/ws").Subrouter()
+	wsRouter.HandleFunc("/replay/{recordingId}", ep.ReplayConnection)
+	wsRouter.HandleFunc("/matchmaking", ep.MatchmakingConnection)
 	wsRouter.HandleFunc("/{game}/{password}", ep.WSConnection)
+	wsRouter.HandleFunc("/{game}", ep.WSConnection)
 
-	r.HandleFunc("/games", ep.currentGames)
-	r.HandleFunc("/health", ep.healthCheck)
+	r.HandleFunc("/games", ep.currentGames).Methods("GET")
+	r.HandleFunc("/games", ep.createGame).Methods("POST")
+	r.HandleFunc("/games/{game}", ep.gameDetails).Methods("GET")
+	r.HandleFunc("/games/{game}/log", ep.gameLog).Methods("GET")
+	r.HandleFunc("/join/{code}", ep.joinCode).Methods("GET")
 
-	lmt := tollbooth.NewLimiter(float64(1), nil)
-	lmt.SetIPLookups([]string{"RemoteAddr", "X-Forwarded-For", "X-Real-IP"}).SetMethods([]string{"POST", "PUT", "GET"})
+	adminRouter := r.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(ep.requireAdmin)
+	adminRouter.HandleFunc("/config", ep.adminConfig).Methods("GET")
+	adminRouter.HandleFunc("/recordings/{recordingId}", ep.recordingLog).Methods("GET")
 
-	return tollbooth.LimitHandler(lmt, r)
+	// health checks and metrics are served outside the rate limiter, so load
+	// balancer probes and scrapes are never throttled
+	root := http.NewServeMux()
+	root.HandleFunc("/health", ep.liveness)
+	root.HandleFunc("/healthz", ep.liveness)
+	root.HandleFunc("/readyz", ep.readiness)
+	root.Handle("/metrics", promhttp.Handler())
+
+	if cfg.RateLimit == 0 {
+		root.Handle("/", r)
+		return root
+	}
+
+	// behind the load balancer RemoteAddr is the balancer's own address, so
+	// clients are told apart by the last X-Forwarded-For entry, which is the
+	// one the balancer appended
+	lmt := tollbooth.NewLimiter(cfg.RateLimit, nil)
+	lmt.SetIPLookups([]string{"X-Forwarded-For", "RemoteAddr"}).SetMethods([]string{"POST", "PUT", "GET"})
+	lmt.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) { rateLimitRejections.Inc() })
+
+	root.Handle("/", tollbooth.LimitHandler(lmt, r))
+
+	return root
 }
 
 type Endpoint struct {
 	hub    *Hub
+	config config.Config
 	logger zerolog.Logger
 }
 
-func (ep Endpoint) healthCheck(w http.ResponseWriter, r *http.Request) {
-	hub := ep.hub
-	gameNames := hub.GetGameNames()
-	ep.logger.Info().Msgf("Health check: total games %d, %s", len(hub.games), gameNames)
+// isAdmin reports whether the request carries the configured admin token,
+// either as a bearer token or, for websockets, in the token query parameter.
+// Without a configured token nobody is an admin.
+func (ep Endpoint) isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+
+	return ep.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ep.config.AdminToken)) == 1
+}
+
+// requireAdmin only lets requests carrying the configured admin token through.
+func (ep Endpoint) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ep.isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (ep Endpoint) adminConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(ep.config.Redacted())
+	if err != nil {
+		ep.logger.Error().Msgf("Error encoding json: %s", err)
+	}
+}
+
+const healthCheckTimeout = 2 * time.Second
+
+type healthStatus struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// liveness reports whether the hub goroutine is still responsive.
+func (ep Endpoint) liveness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status := healthStatus{Status: "ok", Components: map[string]string{"hub": "ok"}}
+	if err := ep.hub.Ping(ctx); err != nil {
+		status.Status = "unavailable"
+		status.Components["hub"] = "unresponsive"
+	}
+
+	ep.writeHealth(w, status)
+}
+
+// readiness reports whether the server should receive new connections.
+func (ep Endpoint) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status := healthStatus{Status: "ok", Components: map[string]string{
+		"hub":   "ok",
+		"store": "disabled",
+	}}
+
+	if err := ep.hub.Ping(ctx); err != nil {
+		status.Status = "unavailable"
+		status.Components["hub"] = "unresponsive"
+	}
+
+	if ep.hub.store != nil {
+		status.Components["store"] = "ok"
+		if err := ep.hub.store.Ping(); err != nil {
+			ep.logger.Error().Err(err).Msg("Game store is unreachable")
+			status.Status = "unavailable"
+			status.Components["store"] = "unreachable"
+		}
+	}
+
+	if ep.hub.IsDraining() {
+		status.Status = "unavailable"
+		status.Components["hub"] = "draining"
+	}
+
+	ep.writeHealth(w, status)
 }
 
+func (ep Endpoint) writeHealth(w http.ResponseWriter, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		ep.logger.Error().Msgf("Error encoding json: %s", err)
+	}
+}
+
+// WSConnection joins an existing game with its password or an invite token.
+// Admins can pass create=true to create the game if it doesn't exist yet,
+// everyone else has to use POST /games.
 func (ep Endpoint) WSConnection(w http.ResponseWriter, r *http.Request) {
+	if ep.hub.IsDraining() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	gameName := mux.Vars(r)["game"]
+	password := mux.Vars(r)["password"]
+	query := r.URL.Query()
+	spectate := query.Get("spectate") == "true"
+	inviteToken := query.Get("invite")
+	// invites are only used up once the player is actually connected
+	invited := false
+
+	var game *Game
+	if query.Get("create") == "true" {
+		if !ep.isAdmin(r) {
+			authFailures.Inc()
+			http.Error(w, "creating a game requires authentication", http.StatusUnauthorized)
+			return
+		}
+
+		game = CreateGame(ep.hub, gameName, password, GameOptions{
+			Title:      query.Get("title"),
+			GameType:   query.Get("gameType"),
+			Visibility: query.Get("visibility"),
+		})
+	}
+	if game == nil {
+		found := ep.hub.ResolveJoinCode(gameName)
+		if found == nil {
+			http.Error(w, "game not found", http.StatusNotFound)
+			return
+		}
+		// players who were already in the game can come back even after the
+		// owner locked it or changed the password, and a connection they
+		// still hold doesn't take up their place
+		session := query.Get("session")
+		returning := found.hasSession(session)
+		if found.isLocked() && !returning {
+			http.Error(w, "game is locked", http.StatusLocked)
+			return
+		}
+		if !spectate && found.isFullFor(session) {
+			http.Error(w, "game is full", http.StatusConflict)
+			return
+		}
+		if !spectate && !found.canPlay(session) {
+			http.Error(w, "game has already started, join as a spectator", http.StatusConflict)
+			return
+		}
+
+		if returning || found.checkPassword(password) {
+			game = found
+		} else if inviteToken != "" && found.hasInvite(inviteToken) {
+			game = found
+			invited = true
+		}
+	}
+	if game == nil {
+		ep.logger.Info().Msgf("password did not match for game %s", gameName)
+		authFailures.Inc()
+		http.Error(w, "wrong password", http.StatusForbidden)
+		return
+	}
+	if game.isBanned(query.Get("session")) {
+		http.Error(w, "banned from this game", http.StatusForbidden)
+		return
+	}
+
 	var upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		ReadBufferSize:  ep.config.ReadBufferSize,
+		WriteBufferSize: ep.config.WriteBufferSize,
 	}
 
 	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
@@ -49,37 +251,212 @@ func (ep Endpoint) WSConnection(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		ep.logger.Error().Msgf("Error upgrading connection: %s", err)
+		upgradeFailures.Inc()
+		return
+	}
+	if invited && !game.useInvite(inviteToken) {
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invite already used"), time.Now().Add(ep.config.WriteWait.Duration()))
+		ws.Close()
+		return
 	}
 
-	gameName := mux.Vars(r)["game"]
-	password := mux.Vars(r)["password"]
+	ep.logger.Info().Msgf("Registering client %s", gameName)
+	CreateNewSocketUser(ep.hub, ws, game, query.Get("username"), query.Get("session"), spectate)
+}
 
-	game := CreateGame(ep.hub, gameName, password)
-	if game == nil {
-		game = GetGame(ep.hub, gameName, password)
+const maxCreateGameBody = 1 << 20
+
+type createGameRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	GameOptions
+}
+
+// createGame creates a game that players can then join over the websocket.
+// Without a name the server picks one, the resulting id is the join code.
+func (ep Endpoint) createGame(w http.ResponseWriter, r *http.Request) {
+	var req createGameRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCreateGameBody)).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case req.Password == "" || strings.Contains(req.Password, "/"):
+		http.Error(w, "password is required and can't contain /", http.StatusBadRequest)
+		return
+	case strings.Contains(req.Name, "/"):
+		http.Error(w, "name can't contain /", http.StatusBadRequest)
+		return
+	case req.Visibility != "" && !validVisibility(req.Visibility):
+		http.Error(w, "visibility must be public, private or unlisted", http.StatusBadRequest)
+		return
+	case req.MaxClients < 0 || req.MaxClients > ep.config.MaxClients:
+		http.Error(w, fmt.Sprintf("capacity must be between 1 and %d", ep.config.MaxClients), http.StatusBadRequest)
+		return
+	case !validSeats(req.Seats):
+		http.Error(w, "seats must have names, at most 32 of them", http.StatusBadRequest)
+		return
+	}
+
+	// the owner creates the game before anyone joins and may drop out of it
+	// for a moment, so it stays until the janitor expires it
+	req.KeepWhenEmpty = true
+
+	var game *Game
+	if req.Name == "" {
+		game = CreateGameWithCode(ep.hub, req.Password, req.GameOptions)
+		if game == nil {
+			http.Error(w, "could not create game", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		game = CreateGame(ep.hub, req.Name, req.Password, req.GameOptions)
+		if game == nil {
+			http.Error(w, "game already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	type output struct {
+		GameInfo
+		JoinCode string `json:"joinCode"`
+	}
+
+	info := game.info()
+	err = json.NewEncoder(w).Encode(output{GameInfo: info, JoinCode: info.ID})
+	if err != nil {
+		ep.logger.Error().Msgf("Error encoding json: %s", err)
+	}
+}
+
+// gameLog downloads the event log of the running game as NDJSON. It is
+// available to admins and to the owner passing their session. Only the
+// current game's recording is exported, never that of an earlier game with
+// the same id.
+func (ep Endpoint) gameLog(w http.ResponseWriter, r *http.Request) {
+	if ep.hub.eventLog == nil {
+		http.Error(w, "event logging is disabled", http.StatusNotFound)
+		return
+	}
+
+	game := ep.hub.FindGame(mux.Vars(r)["game"])
+	if game == nil || !ep.isAdmin(r) && !game.isOwnerSession(r.URL.Query().Get("session")) {
+		authFailures.Inc()
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
+
+	ep.exportRecording(w, r, game.recordingID)
+}
+
+// recordingLog downloads any recording as NDJSON, also after its game has
+// ended. It is only available to admins.
+func (ep Endpoint) recordingLog(w http.ResponseWriter, r *http.Request) {
+	if ep.hub.eventLog == nil {
+		http.Error(w, "event logging is disabled", http.StatusNotFound)
+		return
+	}
+
+	ep.exportRecording(w, r, mux.Vars(r)["recordingId"])
+}
+
+func (ep Endpoint) exportRecording(w http.ResponseWriter, r *http.Request, recordingID string) {
+	if err := ep.hub.recorder.flush(r.Context()); err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", recordingID+".ndjson"))
+
+	err := ep.hub.eventLog.Export(recordingID, w)
+	if errors.Is(err, ErrNoEventLog) {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "no events recorded", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ep.logger.Error().Err(err).Msgf("Error exporting recording %s", recordingID)
+	}
+}
+
+// joinCode resolves a join code to the game it belongs to. Like
+// gameDetails, private games are only resolved with their password.
+func (ep Endpoint) joinCode(w http.ResponseWriter, r *http.Request) {
+	game := ep.hub.ResolveJoinCode(mux.Vars(r)["code"])
 	if game == nil {
-		ep.logger.Info().Msgf("password did not match for game %s", gameName)
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	info := game.info()
+	if info.Visibility == VisibilityPrivate && !game.checkPassword(r.URL.Query().Get("password")) {
+		http.Error(w, "game not found", http.StatusNotFound)
 		return
 	}
 
-	ep.logger.Info().Msgf("Registering client %s, password %s", gameName, password)
-	CreateNewSocketUser(ep.hub, ws, game)
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(info)
+	if err != nil {
+		ep.logger.Error().Msgf("Error encoding json: %s", err)
+	}
 }
 
+// currentGames lists public games, optionally filtered by gameType and
+// hasFreeSeats and paginated with limit and cursor.
 func (ep Endpoint) currentGames(w http.ResponseWriter, r *http.Request) {
-	gameNames := ep.hub.GetGameNames()
-	ep.logger.Info().Msgf("Current games: %s", gameNames)
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	hasFreeSeats, _ := strconv.ParseBool(query.Get("hasFreeSeats"))
+
+	games, nextCursor := ep.hub.ListGames(GameFilter{
+		GameType:     query.Get("gameType"),
+		HasFreeSeats: hasFreeSeats,
+		Cursor:       query.Get("cursor"),
+		Limit:        limit,
+	})
+	if games == nil {
+		games = []GameInfo{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	type output struct {
-		Games []string `json:"games"`
+		Games      []GameInfo `json:"games"`
+		NextCursor string     `json:"nextCursor,omitempty"`
 	}
 
-	err := json.NewEncoder(w).Encode(output{Games: gameNames})
+	err := json.NewEncoder(w).Encode(output{Games: games, NextCursor: nextCursor})
 	if err != nil {
 		ep.logger.Error().Msgf("Error encoding json: %s", err)
 		w.Write([]byte(`{"error": "error encoding json"}`))
 	}
 }
+
+// gameDetails describes a single game. Private games are only described to
+// callers that pass the password, and are otherwise reported as not found.
+func (ep Endpoint) gameDetails(w http.ResponseWriter, r *http.Request) {
+	game := ep.hub.FindGame(mux.Vars(r)["game"])
+	if game == nil {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	info := game.info()
+	if info.Visibility == VisibilityPrivate && !game.checkPassword(r.URL.Query().Get("password")) {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(info)
+	if err != nil {
+		ep.logger.Error().Msgf("Error encoding json: %s", err)
+	}
+}
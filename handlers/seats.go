@@ -0,0 +1,205 @@
+package handlers
+
+const maxSeats = 32
+
+// Seat is a place at the table, such as the red player, that one player at
+// a time can hold. Seats are held by userID so they survive reconnects.
+type Seat struct {
+	Name     string         `json:"name"`
+	Color    string         `json:"color,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type seat struct {
+	Seat
+	userID string
+}
+
+// SeatInfo is the public view of a seat, it doesn't say who holds it.
+type SeatInfo struct {
+	Seat
+	Taken bool `json:"taken"`
+}
+
+type setSeatsPayload struct {
+	Seats []Seat `json:"seats"`
+}
+
+type claimSeatPayload struct {
+	Seat int `json:"seat"`
+}
+
+type swapSeatsPayload struct {
+	Seats []int `json:"seats"`
+}
+
+func newSeats(seats []Seat) []*seat {
+	out := make([]*seat, 0, len(seats))
+	for _, s := range seats {
+		out = append(out, &seat{Seat: s})
+	}
+
+	return out
+}
+
+func validSeats(seats []Seat) bool {
+	if len(seats) > maxSeats {
+		return false
+	}
+	for _, s := range seats {
+		if s.Name == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// seatOf returns the index of the seat held by userID, or -1. game.mu must
+// be held.
+func (game *Game) seatOf(userID string) int {
+	for i, s := range game.seats {
+		if s.userID == userID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// seatInfo describes the seats for the game's details. game.mu must be held.
+func (game *Game) seatInfo() []SeatInfo {
+	seats := make([]SeatInfo, 0, len(game.seats))
+	for _, s := range game.seats {
+		seats = append(seats, SeatInfo{Seat: s.Seat, Taken: s.userID != ""})
+	}
+
+	return seats
+}
+
+// rosterPayload lists the connected clients and who holds each seat.
+// game.mu must be held.
+func (game *Game) rosterPayload() map[string]interface{} {
+	connected := make(map[string]bool)
+	clients := make([]map[string]interface{}, 0, len(game.clients))
+	for client := range game.clients {
+		connected[client.userID] = true
+		entry := map[string]interface{}{
+			"userID":    client.userID,
+			"username":  client.username,
+			"owner":     game.owner == client,
+			"spectator": client.spectator,
+			"bot":       client.bot,
+			"seat":      nil,
+		}
+		if i := game.seatOf(client.userID); i >= 0 {
+			entry["seat"] = i
+		}
+		clients = append(clients, entry)
+	}
+
+	seats := make([]map[string]interface{}, 0, len(game.seats))
+	for i, s := range game.seats {
+		entry := map[string]interface{}{
+			"seat":      i,
+			"name":      s.Name,
+			"color":     s.Color,
+			"metadata":  s.Metadata,
+			"userID":    nil,
+			"connected": false,
+		}
+		if s.userID != "" {
+			entry["userID"] = s.userID
+			entry["connected"] = connected[s.userID]
+		}
+		seats = append(seats, entry)
+	}
+
+	return map[string]interface{}{
+		"players": clients,
+		"seats":   seats,
+	}
+}
+
+// broadcastRoster sends everyone the current roster.
+func broadcastRoster(game *Game, hub *Hub) {
+	game.mu.Lock()
+	payload := game.rosterPayload()
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "roster",
+		EventPayload: payload,
+	}, "", hub.logger)
+}
+
+// handleSeatEvents handles seats. The owner sets up the seats and can swap
+// the players of two seats, players claim a free seat or leave theirs.
+func handleSeatEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	if (eventName == "set seats" || eventName == "swap seats") && !requireOwner(client, eventName) {
+		return
+	}
+
+	var errMessage string
+	game.mu.Lock()
+	switch eventName {
+	case "set seats":
+		var payload setSeatsPayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || !validSeats(payload.Seats) {
+			errMessage = "seats must have names, at most 32 of them"
+			break
+		}
+		game.seats = newSeats(payload.Seats)
+
+	case "claim seat":
+		var payload claimSeatPayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.Seat < 0 || payload.Seat >= len(game.seats) {
+			errMessage = "seat not found"
+			break
+		}
+		if holder := game.seats[payload.Seat].userID; holder != "" {
+			if holder != client.userID {
+				errMessage = "seat is taken"
+			}
+			break
+		}
+
+		if i := game.seatOf(client.userID); i >= 0 {
+			game.seats[i].userID = ""
+		}
+		game.seats[payload.Seat].userID = client.userID
+
+	case "leave seat":
+		i := game.seatOf(client.userID)
+		if i < 0 {
+			errMessage = "you don't have a seat"
+			break
+		}
+		game.seats[i].userID = ""
+
+	case "swap seats":
+		var payload swapSeatsPayload
+		decodePayload(socketEventPayload.EventPayload, &payload)
+		if len(payload.Seats) != 2 {
+			errMessage = "seats must list two seats"
+			break
+		}
+		i, j := payload.Seats[0], payload.Seats[1]
+		if i < 0 || j < 0 || i >= len(game.seats) || j >= len(game.seats) {
+			errMessage = "seat not found"
+			break
+		}
+		game.seats[i].userID, game.seats[j].userID = game.seats[j].userID, game.seats[i].userID
+	}
+	game.mu.Unlock()
+
+	if errMessage != "" {
+		sendError(client, eventName, errMessage)
+		return
+	}
+
+	broadcastRoster(game, client.hub)
+}
@@ -9,13 +9,6 @@ import (
 	"time"
 )
 
-const (
-	writeWait  = 10 * time.Second
-	pongWait   = 60 * time.Second
-	pingPeriod = (pongWait * 9) / 10
-	//maxMessageSize = 512
-)
-
 func unRegisterAndCloseConnection(c *Client) {
 	c.hub.unregister <- c
 	c.webSocketConnection.Close()
@@ -23,23 +16,45 @@ func unRegisterAndCloseConnection(c *Client) {
 
 func setSocketPayloadReadConfig(c *Client) {
 	//c.webSocketConnection.SetReadLimit(maxMessageSize)
+	pongWait := c.hub.config.PongWait.Duration()
 	c.webSocketConnection.SetReadDeadline(time.Now().Add(pongWait))
 	c.webSocketConnection.SetPongHandler(func(string) error { c.webSocketConnection.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 }
 
-func CreateNewSocketUser(hub *Hub, connection *websocket.Conn, game *Game) {
-	uniqueID := uuid.New()
+func CreateNewSocketUser(hub *Hub, connection *websocket.Conn, game *Game, username string, session string, spectator bool) {
+	if !hub.trackPump() {
+		connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(hub.config.WriteWait.Duration()))
+		connection.Close()
+		return
+	}
+
+	sessionID, userID := game.identify(session)
+	game.mu.Lock()
+	game.spectating[sessionID] = spectator
+	game.mu.Unlock()
 	client := &Client{
 		hub:                 hub,
 		webSocketConnection: connection,
-		send:                make(chan SocketEventStruct),
-		userID:              uniqueID.String(),
+		send:                make(chan SocketEventStruct, hub.config.SendQueueSize),
+		userID:              userID,
+		sessionID:           sessionID,
+		username:            username,
 		game:                game,
+		spectator:           spectator,
 	}
 
-	if game.owner == nil {
-		game.owner = client
-	}
+	// the session is only ever sent to its own client, it lets the player
+	// reconnect as the same user by passing it back in the session query parameter
+	client.enqueue(SocketEventStruct{
+		EventName: "session",
+		EventPayload: map[string]interface{}{
+			"sessionID":   client.sessionID,
+			"userID":      client.userID,
+			"spectator":   client.spectator,
+			"recordingID": game.recordingID,
+			"commitment":  game.commitment(),
+		},
+	})
 
 	hub.logger.Info().Msgf("msg logger %s", client.userID)
 	hub.register <- client
@@ -74,6 +89,7 @@ func (c *Client) readPump() {
 		//	break
 		//}
 		c.hub.logger.Info().Msgf("Received payload %s", payload)
+		bytesIn.Add(float64(len(payload)))
 		decoder := json.NewDecoder(bytes.NewReader(payload))
 		decoderErr := decoder.Decode(&socketEventPayload)
 
@@ -82,15 +98,30 @@ func (c *Client) readPump() {
 			break
 		}
 
+		eventsIn.WithLabelValues(eventLabel(socketEventPayload.EventName)).Inc()
+		c.game.touch()
+		recordInbound(c, socketEventPayload)
 		handleSocketPayloadEvents(c, socketEventPayload)
 	}
 }
 
 func handleSocketPayloadEvents(client *Client, socketEventPayload SocketEventStruct) {
+	if !spectatorEvents[socketEventPayload.EventName] && client.isSpectator() {
+		sendError(client, socketEventPayload.EventName, "spectators can't do this")
+		return
+	}
+	if pausedBlockedEvents[socketEventPayload.EventName] && client.game.isPaused() {
+		sendError(client, socketEventPayload.EventName, "the game is paused")
+		return
+	}
+	if turnGatedEvents[socketEventPayload.EventName] && !client.game.isTurnOf(client.userID) {
+		sendError(client, socketEventPayload.EventName, "it's not your turn")
+		return
+	}
+
 	switch socketEventPayload.EventName {
 	case "sync":
-		ownerID := client.game.owner.userID
-		if ownerID != client.userID {
+		if client.game.ownerID() != client.userID {
 			return
 		}
 
@@ -102,9 +133,13 @@ func handleSocketPayloadEvents(client *Client, socketEventPayload SocketEventStr
 			},
 		}
 
+		client.game.mu.Lock()
+		client.game.recordState(hydrate, client.userID, client.hub.config.HistorySize)
+		client.game.mu.Unlock()
+
 		var hydrateUsers []string
-		for c := range client.game.clients {
-			if c.userID == ownerID {
+		for _, c := range client.game.clientList() {
+			if c.userID == client.userID {
 				continue
 			}
 			hydrateUsers = append(hydrateUsers, c.userID)
@@ -148,73 +183,146 @@ func handleSocketPayloadEvents(client *Client, socketEventPayload SocketEventStr
 			},
 		}
 		EmitToConnectedClients(client.game, event, client.userID, client.hub.logger)
+
+	case "create invite":
+		handleCreateInviteEvent(client, socketEventPayload)
+
+	case "kick", "ban":
+		handleRemovePlayerEvent(client, socketEventPayload)
+
+	case "lock", "unlock", "change password", "update game":
+		handleGameUpdateEvents(client, socketEventPayload)
+
+	case "set turn order", "clear turns", "pass", "skip", "reverse":
+		handleTurnEvents(client, socketEventPayload)
+
+	case "timer start", "timer pause", "timer resume", "timer add", "timer cancel":
+		handleTimerEvents(client, socketEventPayload)
+
+	case "time sync":
+		handleTimeSyncEvent(client, socketEventPayload)
+
+	case "roll":
+		handleRollEvent(client, socketEventPayload)
+
+	case "shuffle":
+		handleShuffleEvent(client, socketEventPayload)
+
+	case "reveal seed":
+		handleRevealSeedEvent(client, socketEventPayload)
+
+	case "upload deck", "shuffle deck", "draw", "deal", "discard", "reveal":
+		handleCardEvents(client, socketEventPayload)
+
+	case "undo", "redo", "restore":
+		handleHistoryEvents(client, socketEventPayload)
+
+	case "poll create", "vote", "poll close":
+		handlePollEvents(client, socketEventPayload)
+
+	case "chat", "chat edit", "chat delete":
+		handleChatEvents(client, socketEventPayload)
+
+	case "mute", "unmute":
+		handleMuteEvents(client, socketEventPayload)
+
+	case "start ready check", "cancel ready check", "start game", "pause game", "resume game", "finish game", "reopen game":
+		handleGameStateEvents(client, socketEventPayload)
+
+	case "ready", "unready":
+		handleReadyEvents(client, socketEventPayload)
+
+	case "replace player":
+		handleReplacePlayerEvent(client, socketEventPayload)
+
+	case "set seats", "claim seat", "leave seat", "swap seats":
+		handleSeatEvents(client, socketEventPayload)
+
+	case "add bot":
+		handleAddBotEvent(client, socketEventPayload)
 	}
 }
 
 func connectNewClients(client *Client, event SocketEventStruct) {
-	ownerID := client.game.owner.userID
-	for c := range client.game.clients {
-		if c.userID == ownerID {
-			c.send <- event
+	ownerID := client.game.ownerID()
+	if ownerID == "" {
+		return
+	}
+	event = event.withID()
+	var recipients []string
+	for _, c := range client.game.clientList() {
+		if c.userID == ownerID && c.deliver(event) {
+			recipients = append(recipients, c.userID)
 		}
 	}
+	recordOutbound(client.game, event, recipients)
 }
 
 func hydrateClients(client *Client, socketEventResponse SocketEventStruct, userID []string, logger zerolog.Logger) {
-	if client.game.owner.userID != client.userID {
+	socketEventResponse = socketEventResponse.withID()
+	if client.game.ownerID() != client.userID {
 		return
 	}
-	for client := range client.game.clients {
+	var recipients []string
+	for _, c := range client.game.clientList() {
 		for _, id := range userID {
-			if client.userID == id {
-				logger.Info().Interface("socketEvent", socketEventResponse).Msgf("Emitting to client %s", client.userID)
-				client.send <- socketEventResponse
+			if c.userID == id {
+				logger.Info().Interface("socketEvent", socketEventResponse).Msgf("Emitting to client %s", c.userID)
+				if c.deliver(socketEventResponse) {
+					recipients = append(recipients, c.userID)
+				}
 			}
 		}
 	}
+	recordOutbound(client.game, socketEventResponse, recipients)
 }
 func EmitToConnectedClients(game *Game, socketEventResponse SocketEventStruct, userID string, logger zerolog.Logger) {
-	for client := range game.clients {
+	start := time.Now()
+	defer func() { broadcastDuration.Observe(time.Since(start).Seconds()) }()
+
+	socketEventResponse = socketEventResponse.withID()
+
+	var recipients []string
+	for _, client := range game.clientList() {
 		if client.userID != userID {
 			logger.Info().Interface("socketEvent", socketEventResponse).Msgf("Emitting to client %s", client.userID)
-			client.send <- socketEventResponse
+			if client.deliver(socketEventResponse) {
+				recipients = append(recipients, client.userID)
+			}
 		}
 	}
+	recordOutbound(game, socketEventResponse, recipients)
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	writeWait := c.hub.config.WriteWait.Duration()
+	ticker := time.NewTicker(c.hub.config.PingPeriod())
 	defer func() {
 		ticker.Stop()
 		c.webSocketConnection.Close()
+		// closing the connection ends readPump, which unregisters the client
+		// and closes send, so whatever is still queued leaves the gauge
+		for range c.send {
+			outboundQueueDepth.Dec()
+		}
+		c.hub.pumps.Done()
 	}()
 	for {
 		select {
 		case payload, ok := <-c.send:
-			reqBodyBytes := new(bytes.Buffer)
-			json.NewEncoder(reqBodyBytes).Encode(payload)
-			finalPayload := reqBodyBytes.Bytes()
-
 			c.webSocketConnection.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				c.webSocketConnection.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.webSocketConnection.NextWriter(websocket.TextMessage)
-			if err != nil {
+				closeMessage := []byte{}
+				if c.closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				}
+				c.webSocketConnection.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
-			w.Write(finalPayload)
-
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				json.NewEncoder(reqBodyBytes).Encode(<-c.send)
-				w.Write(reqBodyBytes.Bytes())
-			}
-
-			if err := w.Close(); err != nil {
+			outboundQueueDepth.Dec()
+			if err := c.writeEvent(payload); err != nil {
+				c.hub.logger.Error().Err(err).Interface("client", c.userID).Msgf("Error writing payload")
 				return
 			}
 		case <-ticker.C:
@@ -225,7 +333,64 @@ func (c *Client) writePump() {
 		}
 	}
 }
-func CreateGame(hub *Hub, gameName string, password string) *Game {
+
+// closeTooSlow is the close code for clients whose send queue overflowed.
+const closeTooSlow = 4003
+
+// enqueue sends event to this client alone and records it in the event log.
+func (c *Client) enqueue(event SocketEventStruct) {
+	event = event.withID()
+	if c.deliver(event) {
+		recordOutbound(c.game, event, []string{c.userID})
+	}
+}
+
+// deliver hands event to the client's writePump and reports whether it was
+// queued. Events for closed clients are ignored. A client that can't keep up
+// is disconnected rather than left to miss events without knowing.
+func (c *Client) deliver(event SocketEventStruct) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.send <- event:
+		outboundQueueDepth.Inc()
+		return true
+	default:
+		c.hub.logger.Warn().Interface("client", c.userID).Msgf("Send queue full at %s, disconnecting client", event.EventName)
+		c.closeSend(closeTooSlow, "too slow")
+		// deliver may run on the hub goroutine, so the hub is told
+		// asynchronously to take the client out of its game
+		go func() { c.hub.unregister <- c }()
+		return false
+	}
+}
+
+// writeEvent writes a single event as its own text frame.
+func (c *Client) writeEvent(event SocketEventStruct) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := c.webSocketConnection.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return err
+	}
+
+	eventsOut.WithLabelValues(event.EventName).Inc()
+	bytesOut.Add(float64(len(payload)))
+
+	return nil
+}
+
+func CreateGame(hub *Hub, gameName string, password string, opts GameOptions) *Game {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
 	// check if game already exists
 	for game := range hub.games {
 		if game.id == gameName {
@@ -233,40 +398,96 @@ func CreateGame(hub *Hub, gameName string, password string) *Game {
 		}
 	}
 
-	hub.mu.Lock()
-	defer hub.mu.Unlock()
+	if opts.MaxClients <= 0 {
+		opts.MaxClients = hub.config.MaxClients
+	}
+	if !validVisibility(opts.Visibility) {
+		opts.Visibility = VisibilityPrivate
+	}
+	rng, err := newRandomSource()
+	if err != nil {
+		hub.logger.Error().Err(err).Msgf("Error seeding randomness for game %s", gameName)
+		return nil
+	}
+
 	game := Game{
-		id:         gameName,
-		clients:    make(map[*Client]bool),
-		password:   password,
-		maxClients: 4, // @todo: make this dynamic
+		id:            gameName,
+		recordingID:   uuid.New().String(),
+		clients:       make(map[*Client]bool),
+		password:      password,
+		maxClients:    opts.MaxClients,
+		title:         opts.Title,
+		gameType:      opts.GameType,
+		visibility:    opts.Visibility,
+		createdAt:     time.Now(),
+		lastActivity:  time.Now(),
+		settings:      opts.Settings,
+		pauseOnDrop:   opts.PauseOnDrop,
+		keepWhenEmpty: opts.KeepWhenEmpty,
+		seats:         newSeats(opts.Seats),
+		missing:       make(map[string]bool),
+		invites:       make(map[string]*invite),
+		sessions:      make(map[string]string),
+		banned:        make(map[string]bool),
+		historyAt:     -1,
+		rng:           rng,
+		timers:        make(map[string]*gameTimer),
+		polls:         make(map[string]*poll),
+		mutes:         make(map[string]time.Time),
+		status:        StateLobby,
+		ready:         make(map[string]bool),
+		spectating:    make(map[string]bool),
+		hub:           hub,
 	}
 	RegisterGame(hub, &game)
 
 	return &game
 }
 
-func GetGame(hub *Hub, gameName string, password string) *Game {
-	// check if game already exists
-	for game := range hub.games {
-		if game.id == gameName && game.password == password {
-			return game
-		}
-	}
-
-	return nil
-}
-
 // HandleUserRegisterEvent will handle the Join event for New socket users
 func HandleUserRegisterEvent(hub *Hub, client *Client) {
-	for game := range hub.games {
+	if hub.IsDraining() {
+		closeClient(client, websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+
+	for _, game := range hub.gameList() {
 		if game.id == client.game.id {
 			hub.logger.Info().Msgf("Registering client %s", client.userID)
+			game.mu.Lock()
+			// an owner reconnecting with their session takes the game back from
+			// their old connection
+			if owner := game.owner; owner != nil && owner != client && owner.userID == client.userID {
+				if game.clients[owner] {
+					connectedClients.WithLabelValues(roleOwner).Dec()
+					connectedClients.WithLabelValues(rolePlayer).Inc()
+				}
+				game.owner = client
+			}
 			game.clients[client] = true
+			if game.owner == nil && !client.spectator {
+				game.owner = client
+			}
+			game.lastActivity = time.Now()
+			game.idleWarned = false
+			connectedClients.WithLabelValues(clientRole(client)).Inc()
+			game.mu.Unlock()
+			recordParticipant(client)
+			hub.notify("player joined", map[string]interface{}{
+				"gameID":    game.id,
+				"userID":    client.userID,
+				"username":  client.username,
+				"spectator": client.isSpectator(),
+				"bot":       client.bot,
+			})
+			handlePlayerReturn(game, client, hub)
 		}
 	}
+	sendCardsTo(client)
+	sendChatHistoryTo(client)
+	broadcastRoster(client.game, hub)
 
-	if client.userID == client.game.owner.userID {
+	if client.game.ownerID() == client.userID {
 		return
 	}
 	hub.logger.Info().Msgf("Emitting join event for client %s", client.userID)
@@ -277,16 +498,21 @@ func HandleUserRegisterEvent(hub *Hub, client *Client) {
 }
 
 func HandleUserDisconnectEvent(hub *Hub, client *Client) {
-	for game := range hub.games {
+	// a client whose game went away before it registered still needs its
+	// send channel closed so writePump can finish
+	defer closeClient(client, 0, "")
+
+	for _, game := range hub.gameList() {
 		if game.id == client.game.id {
 			_, ok := game.clients[client]
 			if ok {
 				hub.logger.Info().Msgf("Unregistering client %s", client.userID)
-				delete(game.clients, client)
-				close(client.send)
+				closeClient(client, 0, "")
 
-				if len(game.clients) == 0 {
-					delete(hub.games, game)
+				if len(game.clients) == 0 && !game.keepWhenEmpty {
+					hub.mu.Lock()
+					UnregisterGame(hub, game, "empty")
+					hub.mu.Unlock()
 					return
 				}
 
@@ -294,6 +520,9 @@ func HandleUserDisconnectEvent(hub *Hub, client *Client) {
 					EventName:    "disconnect",
 					EventPayload: client.userID,
 				})
+				checkReady(game, hub)
+				handlePlayerDrop(game, client, hub)
+				broadcastRoster(game, hub)
 			}
 		}
 	}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GameSnapshot is the persisted form of a game, written when the server
+// shuts down so the table can be picked up again later.
+type GameSnapshot struct {
+	ID         string    `json:"id"`
+	MaxClients int       `json:"maxClients"`
+	State      any       `json:"state"`
+	SavedAt    time.Time `json:"savedAt"`
+}
+
+type GameStore interface {
+	SaveGame(snapshot GameSnapshot) error
+	// HasGame reports whether a snapshot exists for the game id
+	HasGame(gameID string) (bool, error)
+	DeleteGame(gameID string) error
+	// PruneGames deletes snapshots saved before the given time
+	PruneGames(before time.Time) (int, error)
+	// Ping reports whether the store can currently be written to
+	Ping() error
+}
+
+// FileGameStore keeps one JSON file per game in dir.
+type FileGameStore struct {
+	dir string
+}
+
+func NewFileGameStore(dir string) (*FileGameStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating snapshot dir: %w", err)
+	}
+
+	return &FileGameStore{dir: dir}, nil
+}
+
+func (s *FileGameStore) SaveGame(snapshot GameSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot: %w", err)
+	}
+
+	if err := os.WriteFile(s.path(snapshot.ID), data, 0o644); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileGameStore) HasGame(gameID string) (bool, error) {
+	_, err := os.Stat(s.path(gameID))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading snapshot: %w", err)
+	}
+
+	return true, nil
+}
+
+func (s *FileGameStore) DeleteGame(gameID string) error {
+	err := os.Remove(s.path(gameID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileGameStore) PruneGames(before time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("error reading snapshot dir: %w", err)
+	}
+
+	pruned := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			return pruned, fmt.Errorf("error deleting snapshot: %w", err)
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+func (s *FileGameStore) Ping() error {
+	f, err := os.CreateTemp(s.dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("error writing to snapshot dir: %w", err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+func (s *FileGameStore) path(gameID string) string {
+	return filepath.Join(s.dir, url.PathEscape(gameID)+".json")
+}
@@ -1,13 +1,76 @@
 package handlers
 
-import "github.com/gorilla/websocket"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
 
 type Game struct {
-	clients    map[*Client]bool
-	id         string
-	password   string
-	maxClients int
-	owner      *Client
+	clients map[*Client]bool
+	id      string
+	// recordingID names this game's event log, the id can be reused by a
+	// later game but the recording never is
+	recordingID string
+	password    string
+	maxClients  int
+	owner       *Client
+	title       string
+	gameType    string
+	visibility  string
+	createdAt   time.Time
+	settings    map[string]any
+	invites     map[string]*invite
+	// sessions maps session ids to the userID they were given so players
+	// keep their identity when they reconnect
+	sessions map[string]string
+	banned   map[string]bool
+	locked   bool
+	// keepWhenEmpty games are only removed by the janitor
+	keepWhenEmpty bool
+
+	lastActivity time.Time
+	idleWarned   bool
+
+	// turns is nil unless the owner set a turn order
+	turns  *turnState
+	timers map[string]*gameTimer
+	// rng is seeded when the game is created, so its commitment is out
+	// before the first roll or shuffle
+	rng *randomSource
+	// cards is nil until the owner uploads a deck
+	cards *cardTable
+	// history holds the last synced states, historyAt is the current one
+	history    []stateVersion
+	historyAt  int
+	polls      map[string]*poll
+	nextPollID int
+	// chat holds the most recent chat messages
+	chat       []*chatMessage
+	nextChatID int
+	// mutes maps muted sessions to when the mute ends, zero for never
+	mutes map[string]time.Time
+	// status is where the game is in its lifecycle, ready holds the players
+	// who are ready during a ready check
+	status string
+	ready  map[string]bool
+	// spectating holds the sessions that joined as spectators
+	spectating map[string]bool
+	// pauseOnDrop pauses the game while players who dropped are missing,
+	// until they return or the drop timer resumes it at resumesAt
+	pauseOnDrop bool
+	missing     map[string]bool
+	dropTimer   *time.Timer
+	resumesAt   time.Time
+	seats       []*seat
+	hub         *Hub
+
+	mu sync.Mutex
+	// state is the last hydrate payload the owner synced
+	state any
 }
 
 type Client struct {
@@ -16,10 +79,127 @@ type Client struct {
 	send                chan SocketEventStruct
 	username            string
 	userID              string
+	sessionID           string
 	game                *Game
+	// spectators follow the game without playing it, spectator is guarded
+	// by game.mu as the owner can turn a spectator into a player
+	spectator bool
+	// bots run inside the server and have no websocket
+	bot bool
+
+	// mu guards closed so nothing is queued once send has been closed
+	mu     sync.Mutex
+	closed bool
+
+	// closeCode and closeReason are sent in the close frame once send is closed
+	closeCode   int
+	closeReason string
 }
 
 type SocketEventStruct struct {
 	EventName    string `json:"eventName"`
 	EventPayload any    `json:"eventPayload"`
+
+	// id is shared by every copy of an outbound event fanned out to clients
+	id uint64
+}
+
+var lastEventID atomic.Uint64
+
+// withID gives the event an id unless it already has one.
+func (event SocketEventStruct) withID() SocketEventStruct {
+	if event.id == 0 {
+		event.id = lastEventID.Add(1)
+	}
+
+	return event
+}
+
+// clientList returns the game's clients so they can be iterated without
+// holding the lock while events are queued.
+func (game *Game) clientList() []*Client {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	clients := make([]*Client, 0, len(game.clients))
+	for client := range game.clients {
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+func (client *Client) isSpectator() bool {
+	client.game.mu.Lock()
+	defer client.game.mu.Unlock()
+
+	return client.spectator
+}
+
+func (game *Game) isOwner(client *Client) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.owner == client
+}
+
+// ownerID returns the owner's userID, or "" while the game has no owner.
+func (game *Game) ownerID() string {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	if game.owner == nil {
+		return ""
+	}
+
+	return game.owner.userID
+}
+
+func (game *Game) isOwnerSession(sessionID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.owner != nil && sessionID != "" && game.owner.sessionID == sessionID
+}
+
+func (game *Game) findClient(userID string) *Client {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	for client := range game.clients {
+		if client.userID == userID {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// identify returns the session and userID for a connecting client, reusing
+// the userID of a known session and starting a new session otherwise.
+func (game *Game) identify(sessionID string) (string, string) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	if userID, ok := game.sessions[sessionID]; ok {
+		return sessionID, userID
+	}
+
+	sessionID = uuid.New().String()
+	userID := uuid.New().String()
+	game.sessions[sessionID] = userID
+
+	return sessionID, userID
+}
+
+func (game *Game) snapshot() GameSnapshot {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return GameSnapshot{
+		ID:         game.id,
+		MaxClients: game.maxClients,
+		State:      game.state,
+		SavedAt:    time.Now(),
+	}
 }
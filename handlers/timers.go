@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+)
+
+// timerTickInterval is how often running timers are broadcast.
+const timerTickInterval = time.Second
+
+type gameTimer struct {
+	name   string
+	userID string
+	// turnClock timers only run while it is their player's turn
+	turnClock bool
+
+	running bool
+	expired bool
+	// frozen timers were running when the game was paused
+	frozen bool
+	// remaining is only current while the timer is paused, a running timer
+	// ends at endsAt
+	remaining time.Duration
+	endsAt    time.Time
+	expiry    *time.Timer
+}
+
+type timerPayload struct {
+	Name       string `json:"name"`
+	UserID     string `json:"userID"`
+	DurationMs int64  `json:"durationMs"`
+	TurnClock  bool   `json:"turnClock"`
+}
+
+type timeSyncPayload struct {
+	ClientTime int64 `json:"clientTime"`
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func (timer *gameTimer) remainingAt(now time.Time) time.Duration {
+	if !timer.running {
+		return timer.remaining
+	}
+	if remaining := timer.endsAt.Sub(now); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// start runs the timer, scheduling its expiry. game.mu must be held.
+func (timer *gameTimer) start(game *Game, now time.Time) {
+	if timer.running || timer.expired {
+		return
+	}
+
+	timer.running = true
+	timer.endsAt = now.Add(timer.remaining)
+	timer.expiry = time.AfterFunc(timer.remaining, func() { expireTimer(game, timer) })
+}
+
+// stop pauses the timer, keeping the time it has left. game.mu must be held.
+func (timer *gameTimer) stop(now time.Time) {
+	if !timer.running {
+		return
+	}
+
+	timer.remaining = timer.remainingAt(now)
+	timer.running = false
+	timer.expiry.Stop()
+}
+
+// timersPayload describes every timer of the game. game.mu must be held.
+func (game *Game) timersPayload(now time.Time) map[string]interface{} {
+	timers := make([]map[string]interface{}, 0, len(game.timers))
+	for _, timer := range game.timers {
+		t := map[string]interface{}{
+			"name":        timer.name,
+			"userID":      timer.userID,
+			"running":     timer.running,
+			"expired":     timer.expired,
+			"remainingMs": timer.remainingAt(now).Milliseconds(),
+		}
+		if timer.running {
+			t["endsAt"] = millis(timer.endsAt)
+		}
+		timers = append(timers, t)
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i]["name"].(string) < timers[j]["name"].(string) })
+
+	return map[string]interface{}{
+		"serverTime": millis(now),
+		"timers":     timers,
+	}
+}
+
+// updateTurnClocks runs the turn clock of the current player and pauses the
+// others. game.mu must be held.
+func (game *Game) updateTurnClocks(now time.Time) {
+	for _, timer := range game.timers {
+		if !timer.turnClock {
+			continue
+		}
+
+		if game.turns != nil && game.turns.order[game.turns.current] == timer.userID {
+			timer.start(game, now)
+		} else {
+			timer.stop(now)
+		}
+	}
+}
+
+func broadcastTimers(game *Game, hub *Hub) {
+	game.mu.Lock()
+	payload := game.timersPayload(time.Now())
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "timer tick",
+		EventPayload: payload,
+	}, "", hub.logger)
+}
+
+// broadcastTurnClocks sends the timers after a turn change when the game has
+// turn clocks.
+func broadcastTurnClocks(game *Game, hub *Hub) {
+	game.mu.Lock()
+	hasTurnClocks := false
+	for _, timer := range game.timers {
+		hasTurnClocks = hasTurnClocks || timer.turnClock
+	}
+	game.mu.Unlock()
+
+	if hasTurnClocks {
+		broadcastTimers(game, hub)
+	}
+}
+
+// expireTimer runs when a timer's time is up.
+func expireTimer(game *Game, timer *gameTimer) {
+	game.mu.Lock()
+	if !timer.running || game.timers[timer.name] != timer || time.Now().Before(timer.endsAt) {
+		game.mu.Unlock()
+		return
+	}
+	timer.running = false
+	timer.expired = true
+	timer.remaining = 0
+	hub := game.hub
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName: "timer expired",
+		EventPayload: map[string]interface{}{
+			"name":       timer.name,
+			"userID":     timer.userID,
+			"serverTime": millis(time.Now()),
+		},
+	}, "", hub.logger)
+}
+
+// tickTimers broadcasts the timers of every game that has one running. It
+// runs on the hub goroutine.
+func tickTimers(hub *Hub) {
+	for _, game := range hub.gameList() {
+		game.mu.Lock()
+		running := false
+		for _, timer := range game.timers {
+			running = running || timer.running
+		}
+		game.mu.Unlock()
+
+		if running {
+			broadcastTimers(game, hub)
+		}
+	}
+}
+
+// freezeTimers stops the running timers while the game is paused.
+// game.mu must be held.
+func (game *Game) freezeTimers(now time.Time) {
+	for _, timer := range game.timers {
+		if timer.running {
+			timer.stop(now)
+			timer.frozen = true
+		}
+	}
+}
+
+// thawTimers restarts the timers freezeTimers stopped. game.mu must be held.
+func (game *Game) thawTimers(now time.Time) {
+	for _, timer := range game.timers {
+		if timer.frozen {
+			timer.frozen = false
+			timer.start(game, now)
+		}
+	}
+}
+
+// stopTimers stops every timer of a game that is going away.
+func (game *Game) stopTimers() {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	for _, timer := range game.timers {
+		timer.stop(time.Now())
+	}
+	game.stopDropTimer()
+}
+
+// handleTimerEvents handles the server owned timers. The owner starts, adds
+// time to and cancels timers, and the owner or a timer's player can pause
+// and resume it.
+func handleTimerEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	var payload timerPayload
+	if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || payload.Name == "" {
+		sendError(client, eventName, "timer name is required")
+		return
+	}
+
+	now := time.Now()
+	game.mu.Lock()
+	isOwner := game.owner == client
+	timer := game.timers[payload.Name]
+
+	var errMessage string
+	switch {
+	case eventName == "timer start":
+		if !isOwner {
+			errMessage = "only the owner can do this"
+			break
+		}
+		if payload.DurationMs <= 0 {
+			errMessage = "durationMs must be positive"
+			break
+		}
+		if payload.TurnClock && payload.UserID == "" {
+			errMessage = "turn clocks need a userID"
+			break
+		}
+
+		if timer != nil {
+			timer.stop(now)
+		}
+		timer = &gameTimer{
+			name:      payload.Name,
+			userID:    payload.UserID,
+			turnClock: payload.TurnClock,
+			remaining: time.Duration(payload.DurationMs) * time.Millisecond,
+		}
+		game.timers[payload.Name] = timer
+		if timer.turnClock {
+			game.updateTurnClocks(now)
+		} else {
+			timer.start(game, now)
+		}
+
+	case timer == nil:
+		errMessage = "timer not found"
+
+	case eventName == "timer pause" || eventName == "timer resume":
+		if !isOwner && timer.userID != client.userID {
+			errMessage = "only the owner or the timer's player can do this"
+			break
+		}
+
+		if eventName == "timer pause" {
+			timer.stop(now)
+		} else {
+			timer.start(game, now)
+		}
+
+	case eventName == "timer add":
+		if !isOwner {
+			errMessage = "only the owner can do this"
+			break
+		}
+
+		running := timer.running
+		timer.stop(now)
+		timer.remaining += time.Duration(payload.DurationMs) * time.Millisecond
+		if timer.remaining < 0 {
+			timer.remaining = 0
+		}
+		if timer.remaining > 0 {
+			timer.expired = false
+		}
+		if running {
+			timer.start(game, now)
+		}
+
+	case eventName == "timer cancel":
+		if !isOwner {
+			errMessage = "only the owner can do this"
+			break
+		}
+
+		timer.stop(now)
+		delete(game.timers, payload.Name)
+	}
+	game.mu.Unlock()
+
+	if errMessage != "" {
+		sendError(client, eventName, errMessage)
+		return
+	}
+
+	broadcastTimers(game, client.hub)
+}
+
+// handleTimeSyncEvent answers with the server time so clients can work out
+// the offset between their clock and the server's.
+func handleTimeSyncEvent(client *Client, socketEventPayload SocketEventStruct) {
+	var payload timeSyncPayload
+	decodePayload(socketEventPayload.EventPayload, &payload)
+
+	client.enqueue(SocketEventStruct{
+		EventName: "time sync response",
+		EventPayload: map[string]interface{}{
+			"clientTime": payload.ClientTime,
+			"serverTime": millis(time.Now()),
+		},
+	})
+}
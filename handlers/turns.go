@@ -0,0 +1,142 @@
+package handlers
+
+import "time"
+
+// turnGatedEvents can only be sent by the player whose turn it is while the
+// game has a turn order.
+var turnGatedEvents = map[string]bool{
+	"message": true,
+	"roll":    true,
+	"shuffle": true,
+	"draw":    true,
+	"discard": true,
+	"reveal":  true,
+}
+
+type turnState struct {
+	order     []string
+	current   int
+	direction int
+	number    int
+}
+
+type turnOrderPayload struct {
+	Order []string `json:"order"`
+}
+
+// isTurnOf reports whether userID may act, which is always the case when the
+// game doesn't use turns.
+func (game *Game) isTurnOf(userID string) bool {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return game.turns == nil || game.turns.order[game.turns.current] == userID
+}
+
+// advance moves the turn on by steps players in the current direction.
+func (turns *turnState) advance(steps int) {
+	n := len(turns.order)
+	turns.current = ((turns.current+steps*turns.direction)%n + n) % n
+	turns.number++
+}
+
+func (turns *turnState) payload() map[string]interface{} {
+	direction := "forward"
+	if turns.direction < 0 {
+		direction = "reverse"
+	}
+
+	return map[string]interface{}{
+		"userID":    turns.order[turns.current],
+		"order":     turns.order,
+		"direction": direction,
+		"turn":      turns.number,
+	}
+}
+
+// handleTurnEvents handles the turn engine. The owner sets or clears the
+// turn order and can skip the current player or reverse the direction, the
+// current player can pass or reverse.
+func handleTurnEvents(client *Client, socketEventPayload SocketEventStruct) {
+	eventName := socketEventPayload.EventName
+	game := client.game
+
+	switch eventName {
+	case "set turn order":
+		if !requireOwner(client, eventName) {
+			return
+		}
+
+		var payload turnOrderPayload
+		if err := decodePayload(socketEventPayload.EventPayload, &payload); err != nil || len(payload.Order) == 0 {
+			sendError(client, eventName, "order must list at least one userID")
+			return
+		}
+		seen := make(map[string]bool)
+		for _, userID := range payload.Order {
+			if player := game.findClient(userID); seen[userID] || player == nil || player.isSpectator() {
+				sendError(client, eventName, "order must list connected players once each")
+				return
+			}
+			seen[userID] = true
+		}
+
+		game.mu.Lock()
+		game.turns = &turnState{order: payload.Order, direction: 1, number: 1}
+		game.updateTurnClocks(time.Now())
+		game.mu.Unlock()
+
+	case "clear turns":
+		if !requireOwner(client, eventName) {
+			return
+		}
+
+		game.mu.Lock()
+		game.turns = nil
+		game.updateTurnClocks(time.Now())
+		game.mu.Unlock()
+
+		EmitToConnectedClients(game, SocketEventStruct{
+			EventName:    "turn changed",
+			EventPayload: map[string]interface{}{"userID": nil},
+		}, "", client.hub.logger)
+		broadcastTurnClocks(game, client.hub)
+		return
+
+	case "pass", "skip", "reverse":
+		game.mu.Lock()
+		turns := game.turns
+		if turns == nil {
+			game.mu.Unlock()
+			sendError(client, eventName, "the game has no turn order")
+			return
+		}
+
+		isCurrent := turns.order[turns.current] == client.userID
+		isOwner := game.owner == client
+		switch {
+		case eventName == "pass" && isCurrent:
+			turns.advance(1)
+		case eventName == "skip" && isOwner:
+			turns.advance(1)
+		case eventName == "reverse" && (isCurrent || isOwner):
+			turns.direction = -turns.direction
+		default:
+			game.mu.Unlock()
+			sendError(client, eventName, "it's not your turn")
+			return
+		}
+		game.updateTurnClocks(time.Now())
+		game.mu.Unlock()
+	}
+
+	game.mu.Lock()
+	payload := game.turns.payload()
+	game.mu.Unlock()
+
+	EmitToConnectedClients(game, SocketEventStruct{
+		EventName:    "turn changed",
+		EventPayload: payload,
+	}, "", client.hub.logger)
+	broadcastTurnClocks(game, client.hub)
+}
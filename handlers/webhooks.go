@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scribe-backend/config"
+)
+
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookMaxBackoff = 10 * time.Minute
+)
+
+// Notifier is told about hub events such as games being created or players
+// joining.
+type Notifier interface {
+	Notify(event string, data map[string]interface{})
+}
+
+// webhookDelivery is one event for one endpoint. It is kept, on disk when a
+// queue dir is set, until the endpoint accepts it or it runs out of attempts.
+type webhookDelivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+}
+
+// Webhooks posts hub events to the configured endpoints, signing every body
+// with HMAC-SHA256 and retrying failed deliveries with exponential backoff.
+type Webhooks struct {
+	urls        []string
+	secret      []byte
+	dir         string
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+	logger      zerolog.Logger
+
+	mu    sync.Mutex
+	queue []*webhookDelivery
+	// fresh deliveries are handed over by Notify and saved by Run, so
+	// callers holding the hub's locks never wait on the disk
+	fresh []*webhookDelivery
+
+	wake chan struct{}
+	done chan struct{}
+	// stopped is closed once Run returns
+	stopped chan struct{}
+}
+
+func NewWebhooks(cfg config.Config, logger zerolog.Logger) (*Webhooks, error) {
+	w := &Webhooks{
+		urls:        cfg.WebhookURLs,
+		secret:      []byte(cfg.WebhookSecret),
+		dir:         cfg.WebhookQueueDir,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookBackoff.Duration(),
+		client:      &http.Client{Timeout: webhookTimeout},
+		logger:      logger,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	if w.dir == "" {
+		return w, nil
+	}
+
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating webhook queue dir: %w", err)
+	}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// load picks up the deliveries left over from the last run.
+func (w *Webhooks) load() error {
+	paths, err := filepath.Glob(filepath.Join(w.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("error listing webhook queue: %w", err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading webhook queue: %w", err)
+		}
+
+		var d webhookDelivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			w.logger.Error().Err(err).Msgf("Dropping unreadable webhook delivery %s", path)
+			os.Remove(path)
+			continue
+		}
+		w.queue = append(w.queue, &d)
+	}
+	if len(w.queue) > 0 {
+		w.logger.Info().Msgf("Resuming %d webhook deliveries", len(w.queue))
+	}
+
+	return nil
+}
+
+func (w *Webhooks) path(id string) string {
+	return filepath.Join(w.dir, id+".json")
+}
+
+// save writes the delivery to the queue dir, through a temporary file so a
+// crash never leaves half a delivery behind.
+func (w *Webhooks) save(d *webhookDelivery) error {
+	if w.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook delivery: %w", err)
+	}
+	tmp := w.path(d.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing webhook delivery: %w", err)
+	}
+
+	return os.Rename(tmp, w.path(d.ID))
+}
+
+func (w *Webhooks) remove(d *webhookDelivery) {
+	if w.dir == "" {
+		return
+	}
+	if err := os.Remove(w.path(d.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		w.logger.Error().Err(err).Msgf("Error removing webhook delivery %s", d.ID)
+	}
+}
+
+// Notify queues the event for every endpoint. It only touches memory, the
+// deliveries are saved and sent by Run.
+func (w *Webhooks) Notify(event string, data map[string]interface{}) {
+	now := time.Now()
+
+	for _, url := range w.urls {
+		id, err := newInviteToken()
+		if err != nil {
+			w.logger.Error().Err(err).Msg("Error generating webhook delivery id")
+			return
+		}
+
+		body, err := json.Marshal(map[string]interface{}{
+			"id":    id,
+			"event": event,
+			"time":  now,
+			"data":  data,
+		})
+		if err != nil {
+			w.logger.Error().Err(err).Msgf("Error encoding %s webhook", event)
+			return
+		}
+
+		d := &webhookDelivery{ID: id, URL: url, Event: event, Body: body, CreatedAt: now, NextAttempt: now}
+		w.mu.Lock()
+		w.fresh = append(w.fresh, d)
+		w.mu.Unlock()
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued events until Close is called.
+func (w *Webhooks) Run() {
+	defer close(w.stopped)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			w.persist()
+			return
+		case <-w.wake:
+		case <-timer.C:
+		}
+
+		w.persist()
+		next := w.deliverDue()
+		timer.Stop()
+		select {
+		case <-timer.C:
+		default:
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// persist saves the deliveries Notify handed over and moves them to the
+// queue.
+func (w *Webhooks) persist() {
+	w.mu.Lock()
+	fresh := w.fresh
+	w.fresh = nil
+	w.mu.Unlock()
+
+	for _, d := range fresh {
+		if err := w.save(d); err != nil {
+			w.logger.Error().Err(err).Msgf("Error queueing %s webhook", d.Event)
+		}
+	}
+
+	w.mu.Lock()
+	w.queue = append(w.queue, fresh...)
+	w.mu.Unlock()
+}
+
+// deliverDue sends every delivery that is due and returns when the next one
+// is, or the zero time when the queue is empty.
+func (w *Webhooks) deliverDue() time.Time {
+	now := time.Now()
+
+	w.mu.Lock()
+	var due []*webhookDelivery
+	for _, d := range w.queue {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, d := range due {
+		select {
+		case <-w.done:
+			return time.Time{}
+		default:
+		}
+
+		err := w.deliver(d)
+		d.Attempts++
+		switch {
+		case err == nil:
+			w.drop(d)
+		case d.Attempts >= w.maxAttempts:
+			w.logger.Error().Err(err).Msgf("Giving up on %s webhook to %s after %d attempts", d.Event, d.URL, d.Attempts)
+			w.drop(d)
+		default:
+			backoff := w.backoff << (d.Attempts - 1)
+			if backoff > webhookMaxBackoff || backoff <= 0 {
+				backoff = webhookMaxBackoff
+			}
+			d.NextAttempt = time.Now().Add(backoff)
+			w.logger.Warn().Err(err).Msgf("Retrying %s webhook to %s in %s", d.Event, d.URL, backoff)
+			if err := w.save(d); err != nil {
+				w.logger.Error().Err(err).Msgf("Error saving webhook delivery %s", d.ID)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var next time.Time
+	for _, d := range w.queue {
+		if next.IsZero() || d.NextAttempt.Before(next) {
+			next = d.NextAttempt
+		}
+	}
+
+	return next
+}
+
+func (w *Webhooks) drop(d *webhookDelivery) {
+	w.remove(d)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, queued := range w.queue {
+		if queued == d {
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// sign returns the signature sent in the X-Scribe-Signature header.
+func (w *Webhooks) sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhooks) deliver(d *webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Scribe-Event", d.Event)
+	req.Header.Set("X-Scribe-Delivery", d.ID)
+	req.Header.Set("X-Scribe-Signature", w.sign(d.Body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Close stops delivering. Deliveries still queued are kept in the queue dir
+// for the next run.
+func (w *Webhooks) Close(ctx context.Context) error {
+	close(w.done)
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notify passes the event on to the hub's notifier, if it has one.
+func (hub *Hub) notify(event string, data map[string]interface{}) {
+	if hub == nil || hub.notifier == nil {
+		return
+	}
+	hub.notifier.Notify(event, data)
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scribe-backend/config"
+)
+
+const testWebhookSecret = "s3cret"
+
+// webhookRequest is a delivery as the endpoint saw it.
+type webhookRequest struct {
+	at        time.Time
+	event     string
+	delivery  string
+	signature string
+	body      []byte
+}
+
+// webhookEndpoint records every request and answers the first failures of
+// them with a 500.
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	failures int
+	requests []webhookRequest
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = append(e.requests, webhookRequest{
+		at:        time.Now(),
+		event:     r.Header.Get("X-Scribe-Event"),
+		delivery:  r.Header.Get("X-Scribe-Delivery"),
+		signature: r.Header.Get("X-Scribe-Signature"),
+		body:      body,
+	})
+	if e.failures > 0 {
+		e.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (e *webhookEndpoint) setFailures(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures = n
+}
+
+func (e *webhookEndpoint) received() []webhookRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]webhookRequest{}, e.requests...)
+}
+
+// waitFor waits until the endpoint has seen n requests.
+func (e *webhookEndpoint) waitFor(t *testing.T, n int) []webhookRequest {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if requests := e.received(); len(requests) >= n {
+			return requests
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("endpoint got %d requests, want %d", len(e.received()), n)
+
+	return nil
+}
+
+func startWebhooks(t *testing.T, url, dir string, maxAttempts int, backoff time.Duration) *Webhooks {
+	t.Helper()
+
+	w, err := NewWebhooks(config.Config{
+		WebhookURLs:        []string{url},
+		WebhookSecret:      testWebhookSecret,
+		WebhookQueueDir:    dir,
+		WebhookMaxAttempts: maxAttempts,
+		WebhookBackoff:     config.Duration(backoff),
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewWebhooks: %v", err)
+	}
+	go w.Run()
+
+	return w
+}
+
+func stopWebhooks(t *testing.T, w *Webhooks) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// queued returns the names of the deliveries saved in dir.
+func queued(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading queue dir: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+// waitForEmptyQueue waits until every delivery has been removed from dir.
+func waitForEmptyQueue(t *testing.T, dir string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(queued(t, dir)) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("queue dir still holds %v", queued(t, dir))
+}
+
+func TestWebhooksSigned(t *testing.T) {
+	endpoint := &webhookEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	w := startWebhooks(t, server.URL, t.TempDir(), 3, time.Second)
+	defer stopWebhooks(t, w)
+
+	w.Notify("game created", map[string]interface{}{"gameID": "g1"})
+	got := endpoint.waitFor(t, 1)[0]
+
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.signature != want {
+		t.Errorf("signature = %q, want %q", got.signature, want)
+	}
+	if got.event != "game created" {
+		t.Errorf("X-Scribe-Event = %q, want %q", got.event, "game created")
+	}
+
+	var body struct {
+		ID    string            `json:"id"`
+		Event string            `json:"event"`
+		Data  map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(got.body, &body); err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	if body.ID == "" || body.ID != got.delivery {
+		t.Errorf("body id = %q, X-Scribe-Delivery = %q", body.ID, got.delivery)
+	}
+	if body.Event != "game created" || body.Data["gameID"] != "g1" {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestWebhooksRetryBackoff(t *testing.T) {
+	endpoint := &webhookEndpoint{failures: 3}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	backoff := 20 * time.Millisecond
+	dir := t.TempDir()
+	w := startWebhooks(t, server.URL, dir, 10, backoff)
+	defer stopWebhooks(t, w)
+
+	w.Notify("game started", map[string]interface{}{"gameID": "g1"})
+	requests := endpoint.waitFor(t, 4)
+	waitForEmptyQueue(t, dir)
+
+	for i, r := range requests {
+		if r.delivery != requests[0].delivery {
+			t.Errorf("attempt %d sent delivery %q, want %q", i+1, r.delivery, requests[0].delivery)
+		}
+	}
+	for i := 1; i < len(requests); i++ {
+		want := backoff << (i - 1)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("attempt %d came %s after the last, want at least %s", i+1, gap, want)
+		}
+	}
+
+	time.Sleep(10 * backoff)
+	if n := len(endpoint.received()); n != 4 {
+		t.Errorf("endpoint got %d requests after delivery, want 4", n)
+	}
+}
+
+func TestWebhooksGiveUp(t *testing.T) {
+	endpoint := &webhookEndpoint{failures: 100}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	backoff := 5 * time.Millisecond
+	dir := t.TempDir()
+	w := startWebhooks(t, server.URL, dir, 3, backoff)
+	defer stopWebhooks(t, w)
+
+	w.Notify("game deleted", map[string]interface{}{"gameID": "g1"})
+	endpoint.waitFor(t, 3)
+	waitForEmptyQueue(t, dir)
+
+	time.Sleep(20 * backoff)
+	if n := len(endpoint.received()); n != 3 {
+		t.Errorf("endpoint got %d requests, want 3", n)
+	}
+}
+
+func TestWebhooksReloadQueue(t *testing.T) {
+	endpoint := &webhookEndpoint{failures: 1}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	// the retry is due long after the first run stops
+	dir := t.TempDir()
+	w := startWebhooks(t, server.URL, dir, 10, time.Hour)
+	w.Notify("player joined", map[string]interface{}{"gameID": "g1", "userID": "u1"})
+	first := endpoint.waitFor(t, 1)[0]
+	stopWebhooks(t, w)
+
+	if names := queued(t, dir); len(names) != 1 {
+		t.Fatalf("queue dir holds %v, want the failed delivery", names)
+	}
+
+	// a delivery read back from disk is due at its saved time, move it up
+	// so the test doesn't wait out the backoff
+	var d webhookDelivery
+	path := w.path(first.delivery)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading saved delivery: %v", err)
+	}
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("decoding saved delivery: %v", err)
+	}
+	if d.Attempts != 1 {
+		t.Errorf("saved delivery has %d attempts, want 1", d.Attempts)
+	}
+	d.NextAttempt = time.Now()
+	if err := w.save(&d); err != nil {
+		t.Fatalf("saving delivery: %v", err)
+	}
+
+	endpoint.setFailures(0)
+	w = startWebhooks(t, server.URL, dir, 10, time.Hour)
+	defer stopWebhooks(t, w)
+
+	second := endpoint.waitFor(t, 2)[1]
+	waitForEmptyQueue(t, dir)
+
+	if second.delivery != first.delivery || string(second.body) != string(first.body) {
+		t.Errorf("reloaded delivery %q differs from %q", second.delivery, first.delivery)
+	}
+}
@@ -80,8 +80,9 @@ func main() {
 
 		albTG, err := lb.NewTargetGroup(ctx, fmt.Sprintf("%s-%s-tg", env, app), &lb.TargetGroupArgs{
 			HealthCheck: lb.TargetGroupHealthCheckArgs{
-				Path: pulumi.StringPtr("/health"),
-				Port: pulumi.Sprintf("%d", 8080),
+				Path:    pulumi.StringPtr("/readyz"),
+				Matcher: pulumi.StringPtr("200"),
+				Port:    pulumi.Sprintf("%d", 8080),
 			},
 			Name:       pulumi.StringPtr(fmt.Sprintf("%s-%s-tg", env, app)),
 			Port:       pulumi.IntPtr(8080),
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
+	"scribe-backend/config"
 	"scribe-backend/handlers"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -12,12 +17,79 @@ import (
 func main() {
 	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 
-	logger.Info().Msg("Starting server")
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	logger.Info().Interface("config", cfg.Redacted()).Msg("Starting server")
+
+	var store handlers.GameStore
+	if cfg.SnapshotDir != "" {
+		fileStore, err := handlers.NewFileGameStore(cfg.SnapshotDir)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Error creating game store")
+		}
+		store = fileStore
+	}
+
+	var eventLog handlers.EventLog
+	if cfg.EventLogDir != "" {
+		fileLog, err := handlers.NewFileEventLog(cfg.EventLogDir)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Error creating event log")
+		}
+		eventLog = fileLog
+	}
+
+	var chatFilter handlers.ChatFilter
+	if len(cfg.ChatBlockedWords) > 0 {
+		chatFilter = handlers.NewWordFilter(cfg.ChatBlockedWords)
+	}
+
+	var notifier handlers.Notifier
+	var webhooks *handlers.Webhooks
+	if len(cfg.WebhookURLs) > 0 {
+		webhooks, err = handlers.NewWebhooks(cfg, logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Error creating webhooks")
+		}
+		go webhooks.Run()
+		notifier = webhooks
+	}
+
+	hub := handlers.NewHub(logger, cfg, store, eventLog, chatFilter, notifier)
+	go hub.Run()
 
 	router := mux.NewRouter()
-	handlers.Routes(router, logger)
+	handler := handlers.Routes(router, hub, cfg, logger)
+
+	server := &http.Server{Addr: cfg.Addr, Handler: handler}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal().Err(err).Msg("Error starting server")
+		}
+	}()
 
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		logger.Fatal().Err(err).Msg("Error starting server")
+	<-ctx.Done()
+	logger.Info().Msg("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration())
+	defer cancel()
+
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("Error draining connections")
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("Error shutting down server")
+	}
+	if webhooks != nil {
+		if err := webhooks.Close(shutdownCtx); err != nil {
+			logger.Error().Err(err).Msg("Error stopping webhooks")
+		}
 	}
 }